@@ -5,16 +5,18 @@ go 1.25
 require (
 	github.com/daixiang0/gci v0.13.7
 	github.com/dave/dst v0.27.3
+	github.com/hexops/gotextdiff v1.0.3
 	github.com/samber/lo v1.52.0
 	github.com/spf13/cobra v1.10.2
 	golang.org/x/mod v0.31.0
+	golang.org/x/tools v0.39.0
 	gonum.org/v1/gonum v0.16.0
+	gopkg.in/yaml.v3 v3.0.1
 	mvdan.cc/gofumpt v0.9.2
 )
 
 require (
 	github.com/google/go-cmp v0.7.0 // indirect
-	github.com/hexops/gotextdiff v1.0.3 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/sergi/go-diff v1.4.0 // indirect
 	github.com/spf13/pflag v1.0.9 // indirect
@@ -23,7 +25,5 @@ require (
 	go.uber.org/zap v1.24.0 // indirect
 	golang.org/x/sync v0.18.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
-	golang.org/x/tools v0.39.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
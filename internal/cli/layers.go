@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/werf/wormatter/pkg/formatter"
+)
+
+func init() {
+	layersCmd.Flags().StringVar(&layersFormat, "format", formatter.LayersFormatText, "Output format: text, json, dot or mermaid")
+	layersCmd.Flags().BoolVarP(&layersPackage, "package", "p", false, "Use the call graph of the whole package of the file")
+	layersCmd.Flags().BoolVar(&layersTests, "tests", false, "Include test files in the package call graph")
+	rootCmd.AddCommand(layersCmd)
+}
+
+var (
+	layersCmd = &cobra.Command{
+		Use:   "layers <file|package>",
+		Short: "Print the call graph and architectural layers functions are ordered by",
+		Long:  "Print every function and method with its architectural layer, its callees and the cycle it belongs to. A file is layered like the formatter does without package mode; a package directory uses the call graph of the whole package.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runLayers,
+	}
+
+	layersPackage bool
+	layersTests   bool
+
+	layersFormat string
+)
+
+func runLayers(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	opts := formatter.Options{
+		PackageLayers:      layersPackage || layersTests,
+		PackageLayersTests: layersTests,
+		PackageMode:        layersPackage || layersTests,
+	}
+	if err := applyConfig(&opts); err != nil {
+		return err
+	}
+
+	layers, err := formatter.InspectLayers(args[0], opts)
+	if err != nil {
+		return err
+	}
+
+	return formatter.WriteLayers(os.Stdout, layers, layersFormat)
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -10,8 +11,22 @@ import (
 )
 
 func init() {
+	rootCmd.Flags().BoolVar(&attachAssertions, "attach-assertions", false, "Place interface compliance assertions (var _ I = (*T)(nil)) right after the asserted type")
 	rootCmd.Flags().BoolVarP(&checkOnly, "check", "c", false, "Check if files need formatting (exit 1 if changes needed)")
+	rootCmd.Flags().StringVar(&configPath, "config", "", "Path to the configuration file (default: "+formatter.ConfigFileName+" in the working directory, if present)")
+	rootCmd.Flags().StringVar(&crashDir, "crash-dir", "", "Save the input of every file that crashes the formatter to this directory")
+	rootCmd.Flags().BoolVar(&debugMode, "debug", false, "Print stack traces of internal errors")
+	rootCmd.Flags().BoolVar(&enumLayout, "enum-layout", false, "Place iota blocks and constants of local types right after their type")
 	rootCmd.Flags().StringArrayVarP(&excludePatterns, "exclude", "e", nil, "Exclude files matching glob pattern (can be specified multiple times)")
+	rootCmd.Flags().BoolVar(&keepBlocks, "keep-blocks", false, "Keep parenthesised const and var blocks as units instead of merging them")
+	rootCmd.Flags().BoolVar(&moveOrphanMethods, "move-orphan-methods", false, "Move methods into the file of the package declaring their receiver type (requires --package)")
+	rootCmd.Flags().BoolVar(&packageLayers, "package-layers", false, "Compute architectural layers from the call graph of the whole package (requires --package)")
+	rootCmd.Flags().BoolVar(&packageLayersTests, "package-layers-tests", false, "Include test files in the package call graph (requires --package-layers)")
+	rootCmd.Flags().BoolVarP(&packageMode, "package", "p", false, "Load all files of each package together and rewrite struct literals across files")
+	rootCmd.Flags().BoolVar(&stable, "stable", false, "Only move declarations, specs and fields that violate a layout rule, keeping the original order otherwise")
+	rootCmd.Flags().BoolVar(&typeAware, "type-aware", false, "Resolve struct literal types with the type checker, including structs from other packages")
+	rootCmd.Flags().BoolVar(&verify, "verify", false, "Fail if the formatted code is not equivalent to the original (modulo reordering)")
+	rootCmd.Flags().BoolVar(&verifyIdempotent, "verify-idempotent", false, "Format each file twice in memory and report files where the second pass changes the output")
 }
 
 var (
@@ -26,7 +41,22 @@ var (
 	}
 	version = "dev"
 
-	checkOnly bool
+	attachAssertions   bool
+	checkOnly          bool
+	debugMode          bool
+	enumLayout         bool
+	keepBlocks         bool
+	moveOrphanMethods  bool
+	packageLayers      bool
+	packageLayersTests bool
+	packageMode        bool
+	stable             bool
+	typeAware          bool
+	verify             bool
+	verifyIdempotent   bool
+
+	configPath string
+	crashDir   string
 )
 
 func Execute() {
@@ -35,28 +65,69 @@ func Execute() {
 	}
 }
 
-func run(_ *cobra.Command, args []string) error {
+func run(cmd *cobra.Command, args []string) error {
+	// Errors past this point are about the formatted files, not the usage.
+	cmd.SilenceUsage = true
+
 	opts := formatter.Options{
-		CheckOnly:       checkOnly,
-		ExcludePatterns: excludePatterns,
+		AttachAssertions:   attachAssertions,
+		CheckOnly:          checkOnly,
+		CrashDir:           crashDir,
+		Debug:              debugMode,
+		EnumLayout:         enumLayout,
+		ExcludePatterns:    excludePatterns,
+		KeepBlocks:         keepBlocks,
+		MoveOrphanMethods:  moveOrphanMethods,
+		PackageLayers:      packageLayers,
+		PackageLayersTests: packageLayersTests,
+		PackageMode:        packageMode,
+		Stable:             stable,
+		TypeAware:          typeAware,
+		Verify:             verify,
+		VerifyIdempotent:   verifyIdempotent,
+	}
+
+	if err := applyConfig(&opts); err != nil {
+		return err
 	}
 
+	var errs []error
 	for _, path := range args {
 		info, err := os.Stat(path)
 		if err != nil {
-			return fmt.Errorf("cannot access %q: %w", path, err)
+			errs = append(errs, fmt.Errorf("cannot access %q: %w", path, err))
+			continue
 		}
 
 		if info.IsDir() {
-			if err := formatter.FormatDirectory(path, opts); err != nil {
-				return err
-			}
+			err = formatter.FormatDirectory(path, opts)
 		} else {
-			if err := formatter.FormatFile(path, opts); err != nil {
-				return err
-			}
+			err = formatter.FormatFile(path, opts)
 		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// applyConfig loads the configuration file given with --config, or the default
+// one if it exists, into opts.
+func applyConfig(opts *formatter.Options) error {
+	path := configPath
+	if path == "" {
+		if _, err := os.Stat(formatter.ConfigFileName); err != nil {
+			return nil
+		}
+		path = formatter.ConfigFileName
+	}
+
+	cfg, err := formatter.LoadConfig(path)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
 	}
+	cfg.Apply(opts)
 
 	return nil
 }
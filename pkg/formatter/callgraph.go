@@ -2,37 +2,45 @@ package formatter
 
 import (
 	"github.com/dave/dst"
+	"github.com/samber/lo"
 	"gonum.org/v1/gonum/graph/simple"
 	"gonum.org/v1/gonum/graph/topo"
 )
 
-func assignLayers(callGraph map[string][]string, funcNames map[string]bool) map[string]int {
-	g := simple.NewDirectedGraph()
-	nameToID := make(map[string]int64)
-	idToName := make(map[int64]string)
+// layering holds how functions are ordered: the call graph they are sorted by
+// and the strategy (see the FuncSort constants).
+type layering struct {
+	callsOnly bool
+	funcSort  string
+	// packageLayers holds the layers computed from the call graph of the whole
+	// package, by funcKey. If nil, layers are computed from the functions being
+	// sorted.
+	packageLayers map[string]int
+	// stable skips layer sorting, keeping the original order, unless funcSort
+	// is set explicitly.
+	stable bool
+}
 
-	var nextID int64
-	for name := range funcNames {
-		nameToID[name] = nextID
-		idToName[nextID] = name
-		g.AddNode(simple.Node(nextID))
-		nextID++
-	}
+// keepsOrder reports whether functions keep their original order instead of
+// being sorted by layer.
+func (l layering) keepsOrder() bool {
+	return l.stable && l.funcSort == ""
+}
 
-	for caller, callees := range callGraph {
-		for _, callee := range callees {
-			g.SetEdge(g.NewEdge(simple.Node(nameToID[caller]), simple.Node(nameToID[callee])))
-		}
+func (l layering) layers(funcs []*dst.FuncDecl) map[string]int {
+	if l.packageLayers != nil {
+		return l.packageLayers
 	}
 
-	sccs := topo.TarjanSCC(g)
+	funcNames := lo.SliceToMap(funcs, func(fn *dst.FuncDecl) (string, bool) {
+		return funcKey(fn), true
+	})
 
-	sccID := make(map[string]int)
-	for i, scc := range sccs {
-		for _, node := range scc {
-			sccID[idToName[node.ID()]] = i
-		}
-	}
+	return assignLayers(buildCallGraph(funcs, funcNames, l.callsOnly), funcNames)
+}
+
+func assignLayers(callGraph map[string][]string, funcNames map[string]bool) map[string]int {
+	sccs, sccID := findSCCs(callGraph, funcNames)
 
 	sccGraph := make(map[int][]int)
 	for i := range sccs {
@@ -78,33 +86,146 @@ func assignLayers(callGraph map[string][]string, funcNames map[string]bool) map[
 	return layers
 }
 
-func buildCallGraph(funcs []*dst.FuncDecl, localFuncs map[string]bool) map[string][]string {
+// buildCallGraph returns the local functions each function references, keyed
+// by funcKey. Any reference counts: calls, functions passed as values
+// (sort.Slice(x, less)), go and defer statements, method values (s.handle)
+// and method expressions ((*Server).handle). Selectors on the receiver
+// resolve to methods of the receiver type. With callsOnly, only the called
+// function of a call expression counts.
+func buildCallGraph(funcs []*dst.FuncDecl, localFuncs map[string]bool, callsOnly bool) map[string][]string {
 	graph := make(map[string][]string)
 
 	for _, fn := range funcs {
-		name := fn.Name.Name
-		graph[name] = []string{}
+		key := funcKey(fn)
+		graph[key] = []string{}
 
 		if fn.Body == nil {
 			continue
 		}
 
-		dst.Inspect(fn.Body, func(n dst.Node) bool {
-			call, ok := n.(*dst.CallExpr)
-			if !ok {
-				return true
-			}
-			ident, ok := call.Fun.(*dst.Ident)
-			if !ok {
-				return true
+		addEdge := func(callee string) {
+			if localFuncs[callee] && callee != key {
+				graph[key] = append(graph[key], callee)
 			}
-			if localFuncs[ident.Name] && ident.Name != name {
-				graph[name] = append(graph[name], ident.Name)
+		}
+		recvName, recvType := getReceiverName(fn), getReceiverTypeName(fn)
+
+		// Names declared in the function shadow the package functions.
+		declared := declaredNames(fn)
+
+		var visit func(n dst.Node) bool
+		visit = func(n dst.Node) bool {
+			switch node := n.(type) {
+			case *dst.CallExpr:
+				if !callsOnly {
+					break
+				}
+				switch fun := node.Fun.(type) {
+				case *dst.Ident:
+					if !declared[fun.Name] {
+						addEdge(fun.Name)
+					}
+				case *dst.SelectorExpr:
+					addEdge(selectorTarget(fun, recvName, recvType))
+				}
+			case *dst.Ident:
+				if !callsOnly && !declared[node.Name] {
+					addEdge(node.Name)
+				}
+			case *dst.KeyValueExpr:
+				// Struct literal keys are field names, not references.
+				if _, ok := node.Key.(*dst.Ident); !ok {
+					dst.Inspect(node.Key, visit)
+				}
+				dst.Inspect(node.Value, visit)
+
+				return false
+			case *dst.SelectorExpr:
+				if !callsOnly {
+					addEdge(selectorTarget(node, recvName, recvType))
+				}
+				// The selected name is not a reference to a local function.
+				dst.Inspect(node.X, visit)
+
+				return false
 			}
 
 			return true
-		})
+		}
+		dst.Inspect(fn.Body, visit)
 	}
 
 	return graph
 }
+
+// findSCCs returns the strongly connected components of the call graph, and
+// the index of the component of every function. Functions calling each other
+// in a cycle share a component.
+func findSCCs(callGraph map[string][]string, funcNames map[string]bool) ([][]string, map[string]int) {
+	g := simple.NewDirectedGraph()
+	nameToID := make(map[string]int64)
+	idToName := make(map[int64]string)
+
+	var nextID int64
+	for name := range funcNames {
+		nameToID[name] = nextID
+		idToName[nextID] = name
+		g.AddNode(simple.Node(nextID))
+		nextID++
+	}
+
+	for caller, callees := range callGraph {
+		for _, callee := range callees {
+			g.SetEdge(g.NewEdge(simple.Node(nameToID[caller]), simple.Node(nameToID[callee])))
+		}
+	}
+
+	var sccs [][]string
+	sccID := make(map[string]int)
+	for i, scc := range topo.TarjanSCC(g) {
+		names := make([]string, 0, len(scc))
+		for _, node := range scc {
+			name := idToName[node.ID()]
+			names = append(names, name)
+			sccID[name] = i
+		}
+		sccs = append(sccs, names)
+	}
+
+	return sccs, sccID
+}
+
+// funcKey identifies a function in the call graph: its name, qualified with the
+// receiver type for methods (Server.Run).
+func funcKey(fn *dst.FuncDecl) string {
+	if fn.Recv != nil {
+		return getReceiverTypeName(fn) + "." + fn.Name.Name
+	}
+
+	return fn.Name.Name
+}
+
+// selectorTarget returns the call graph key of the method a selector may refer
+// to: a method of the receiver type (s.handle) or a method expression
+// (Server.handle, (*Server).handle). It returns an empty string otherwise.
+func selectorTarget(sel *dst.SelectorExpr, recvName, recvType string) string {
+	if ident, ok := sel.X.(*dst.Ident); ok && recvName != "" && ident.Name == recvName {
+		return recvType + "." + sel.Sel.Name
+	}
+
+	x := sel.X
+	if paren, ok := x.(*dst.ParenExpr); ok {
+		x = paren.X
+	}
+	if star, ok := x.(*dst.StarExpr); ok {
+		x = star.X
+	}
+	switch x.(type) {
+	case *dst.Ident, *dst.IndexExpr, *dst.IndexListExpr:
+		if typeName := extractTypeName(x); typeName != "" {
+			return typeName + "." + sel.Sel.Name
+		}
+	}
+
+	return ""
+}
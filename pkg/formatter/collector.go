@@ -2,37 +2,102 @@ package formatter
 
 import (
 	"go/token"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/dave/dst"
 )
 
 type declCollector struct {
-	blankVarSpecs  []dst.Spec
-	constSpecs     []dst.Spec
-	constructors   map[string][]*dst.FuncDecl
+	// assertionSpecs holds the interface compliance assertions of local types,
+	// by type name, if they are laid out after their type.
+	assertionSpecs   map[string][]dst.Spec
+	attachAssertions bool
+	blankVarSpecs    []dst.Spec
+	// constBlocks and varBlocks hold the parenthesised blocks kept as units,
+	// and the declarations carrying //go: directives.
+	constBlocks        []*dst.GenDecl
+	constSpecs         []dst.Spec
+	constructorMatcher *constructorMatcher
+	constructors       map[string][]*dst.FuncDecl
+	// enumConstSpecs and enumIotaDecls hold the typed constants and iota blocks
+	// of local types, by type name, if they are laid out after their type.
+	enumConstSpecs map[string][]dst.Spec
+	enumIotaDecls  map[string][]*dst.GenDecl
+	enumLayout     bool
 	functions      []dst.Decl
-	imports        []dst.Decl
-	initFuncs      []*dst.FuncDecl
-	iotaConstDecls []*dst.GenDecl
-	mainFunc       *dst.FuncDecl
-	methodsByType  map[string][]*dst.FuncDecl
-	orphanMethods  []*dst.FuncDecl
-	typeDecls      []*dst.GenDecl
-	typeNames      map[string]bool
-	varSpecs       []dst.Spec
-}
-
-func newDeclCollector() *declCollector {
+	// groupedVarSpecs holds the var specs matching varGroups, by group index.
+	groupedVarSpecs [][]dst.Spec
+	imports         []dst.Decl
+	initFuncs       []*dst.FuncDecl
+	// interfaceMethods groups the methods implementing interfaces.
+	interfaceMethods *methodGrouper
+	iotaConstDecls   []*dst.GenDecl
+	keepBlocks       bool
+	layering         layering
+	mainFunc         *dst.FuncDecl
+	methodsByType    map[string][]*dst.FuncDecl
+	// optionFuncs holds the functions returning an option type, by option type.
+	optionFuncs map[string][]*dst.FuncDecl
+	// optionTypes maps functional option types (type Option func(*Server)) to
+	// the struct they configure.
+	optionTypes   map[string]string
+	orphanMethods []*dst.FuncDecl
+	// packageTypes holds the declaration order of the types of the package, by
+	// name, in package mode.
+	packageTypes map[string]int
+	stable       bool
+	// testFuncs holds TestMain, tests, benchmarks, fuzz targets and examples if
+	// the file is a test file laid out with testLayout.
+	testFuncs    []*dst.FuncDecl
+	testLayout   string
+	typeDecls    []*dst.GenDecl
+	typeNames    map[string]bool
+	typeOrdering typeOrdering
+	varBlocks    []*dst.GenDecl
+	varGroups    []VarGroup
+	varSpecs     []dst.Spec
+}
+
+func newDeclCollector(filePath string, packageLayers, packageTypes map[string]int, opts Options) *declCollector {
+	typeOrder := opts.TypeOrder
+	if typeOrder == nil {
+		typeOrder = DefaultTypeOrder
+	}
+
 	return &declCollector{
-		constructors:  make(map[string][]*dst.FuncDecl),
-		methodsByType: make(map[string][]*dst.FuncDecl),
-		typeNames:     make(map[string]bool),
+		assertionSpecs:     make(map[string][]dst.Spec),
+		attachAssertions:   opts.AttachAssertions,
+		constructorMatcher: newConstructorMatcher(opts.ConstructorPatterns, opts.ConstructorsByReturnType),
+		constructors:       make(map[string][]*dst.FuncDecl),
+		enumConstSpecs:     make(map[string][]dst.Spec),
+		enumIotaDecls:      make(map[string][]*dst.GenDecl),
+		enumLayout:         opts.EnumLayout,
+		groupedVarSpecs:    make([][]dst.Spec, len(opts.VarGroups)),
+		interfaceMethods:   newMethodGrouper(opts.InterfaceMethods),
+		keepBlocks:         opts.KeepBlocks,
+		layering:           layering{callsOnly: opts.LayerCallsOnly, funcSort: opts.FuncSort, packageLayers: packageLayers, stable: opts.Stable},
+		methodsByType:      make(map[string][]*dst.FuncDecl),
+		optionFuncs:        make(map[string][]*dst.FuncDecl),
+		optionTypes:        make(map[string]string),
+		packageTypes:       packageTypes,
+		stable:             opts.Stable,
+		testLayout:         testLayoutOf(filePath, opts.TestLayout),
+		typeNames:          make(map[string]bool),
+		typeOrdering: typeOrdering{
+			categories: typeOrder,
+			fileName:   filePath,
+			within:     opts.TypeSort,
+		},
+		varGroups: opts.VarGroups,
 	}
 }
 
 func (c *declCollector) collect(f *dst.File) {
 	c.collectTypeNames(f)
+	c.constructorMatcher.collectImplementers(f)
+	c.interfaceMethods.collectInterfaces(f)
 
 	for _, decl := range f.Decls {
 		switch d := decl.(type) {
@@ -44,6 +109,129 @@ func (c *declCollector) collect(f *dst.File) {
 	}
 }
 
+func (c *declCollector) collectGenDecl(d *dst.GenDecl) {
+	switch d.Tok {
+	case token.IMPORT:
+		c.imports = append(c.imports, d)
+	case token.CONST:
+		if hasIota(d) {
+			if typeName := c.enumTypeName(d.Specs[0]); typeName != "" {
+				c.enumIotaDecls[typeName] = append(c.enumIotaDecls[typeName], d)
+			} else {
+				c.iotaConstDecls = append(c.iotaConstDecls, d)
+			}
+
+			return
+		}
+
+		if c.keepBlocks && d.Lparen || hasGoDirective(d) || hasBlockHeader(d) {
+			c.constBlocks = append(c.constBlocks, d)
+
+			return
+		}
+
+		moveDeclDecorationsToSpecs(d)
+		for _, spec := range d.Specs {
+			if typeName := c.enumTypeName(spec); typeName != "" {
+				c.enumConstSpecs[typeName] = append(c.enumConstSpecs[typeName], spec)
+			} else {
+				c.constSpecs = append(c.constSpecs, spec)
+			}
+		}
+	case token.VAR:
+		if c.keepBlocks && d.Lparen || hasGoDirective(d) || hasBlockHeader(d) {
+			c.varBlocks = append(c.varBlocks, d)
+
+			return
+		}
+
+		moveDeclDecorationsToSpecs(d)
+		for _, spec := range d.Specs {
+			if isBlankVarSpec(spec) {
+				if typeName := c.assertedTypeName(spec); typeName != "" {
+					c.assertionSpecs[typeName] = append(c.assertionSpecs[typeName], spec)
+				} else {
+					c.blankVarSpecs = append(c.blankVarSpecs, spec)
+				}
+			} else if i := matchVarGroup(spec, c.varGroups); i >= 0 {
+				c.groupedVarSpecs[i] = append(c.groupedVarSpecs[i], spec)
+			} else {
+				c.varSpecs = append(c.varSpecs, spec)
+			}
+		}
+	case token.TYPE:
+		c.typeDecls = append(c.typeDecls, d)
+	}
+}
+
+func (c *declCollector) sort() {
+	sortSpecsByExportabilityThenName(c.constSpecs, c.stable)
+	for typeName := range c.enumConstSpecs {
+		sortSpecsByExportabilityThenName(c.enumConstSpecs[typeName], c.stable)
+	}
+	sortSpecsByExportabilityThenName(c.varSpecs, c.stable)
+	for _, specs := range c.groupedVarSpecs {
+		sortSpecsByExportabilityThenName(specs, c.stable)
+	}
+
+	for _, block := range c.constBlocks {
+		sortSpecsByExportabilityThenName(block.Specs, c.stable)
+		addEmptyLinesBetweenSpecGroups(block.Specs)
+		separateLparenComment(block)
+	}
+	for _, block := range c.varBlocks {
+		c.sortVarBlock(block)
+		separateLparenComment(block)
+	}
+
+	if !c.stable {
+		for typeName := range c.constructors {
+			sortFuncDeclsByName(c.constructors[typeName])
+		}
+
+		for optionType := range c.optionFuncs {
+			sortFuncDeclsByName(c.optionFuncs[optionType])
+		}
+	}
+
+	for typeName := range c.methodsByType {
+		sortFuncDeclsByExportabilityThenLayer(c.methodsByType[typeName], c.layering)
+		c.methodsByType[typeName] = c.interfaceMethods.group(c.methodsByType[typeName])
+	}
+
+	c.orphanMethods = c.groupOrphanMethods()
+
+	sortDeclsByExportabilityThenLayer(c.functions, c.layering)
+
+	if c.testLayout != "" {
+		sortTestFuncs(c.testFuncs, c.testLayout)
+		c.functions = moveHelpersFirst(c.functions)
+	}
+}
+
+// assertedTypeName returns the local type referenced by the value of a blank
+// identifier assertion (var _ I = (*T)(nil)) if assertions are laid out after
+// their type, and an empty string otherwise.
+func (c *declCollector) assertedTypeName(spec dst.Spec) string {
+	vs, ok := spec.(*dst.ValueSpec)
+	if !c.attachAssertions || !ok {
+		return ""
+	}
+
+	var typeName string
+	for _, value := range vs.Values {
+		dst.Inspect(value, func(n dst.Node) bool {
+			if ident, ok := n.(*dst.Ident); ok && typeName == "" && c.typeNames[ident.Name] {
+				typeName = ident.Name
+			}
+
+			return typeName == ""
+		})
+	}
+
+	return typeName
+}
+
 func (c *declCollector) collectFuncDecl(d *dst.FuncDecl) {
 	switch {
 	case d.Recv != nil:
@@ -57,67 +245,201 @@ func (c *declCollector) collectFuncDecl(d *dst.FuncDecl) {
 		c.initFuncs = append(c.initFuncs, d)
 	case d.Name.Name == "main":
 		c.mainFunc = d
-	case strings.HasPrefix(d.Name.Name, "New") || strings.HasPrefix(d.Name.Name, "new"):
-		if typeName := findConstructorType(d, c.typeNames); typeName != "" {
+	case c.testLayout != "" && testFuncRank(d) >= 0:
+		c.testFuncs = append(c.testFuncs, d)
+	case c.optionTypes[getSingleResultTypeName(d)] != "":
+		optionType := getSingleResultTypeName(d)
+		c.optionFuncs[optionType] = append(c.optionFuncs[optionType], d)
+	default:
+		if typeName := c.constructorMatcher.findType(d, c.typeNames); typeName != "" {
 			c.constructors[typeName] = append(c.constructors[typeName], d)
 		} else {
 			c.functions = append(c.functions, d)
 		}
-	default:
-		c.functions = append(c.functions, d)
-	}
-}
-
-func (c *declCollector) collectGenDecl(d *dst.GenDecl) {
-	switch d.Tok {
-	case token.IMPORT:
-		c.imports = append(c.imports, d)
-	case token.CONST:
-		if hasIota(d) {
-			c.iotaConstDecls = append(c.iotaConstDecls, d)
-		} else {
-			c.constSpecs = append(c.constSpecs, d.Specs...)
-		}
-	case token.VAR:
-		for _, spec := range d.Specs {
-			if isBlankVarSpec(spec) {
-				c.blankVarSpecs = append(c.blankVarSpecs, spec)
-			} else {
-				c.varSpecs = append(c.varSpecs, spec)
-			}
-		}
-	case token.TYPE:
-		c.typeDecls = append(c.typeDecls, d)
 	}
 }
 
 func (c *declCollector) collectTypeNames(f *dst.File) {
+	structNames := make(map[string]bool)
+	var funcTypes []*dst.TypeSpec
 	for _, decl := range f.Decls {
 		gd, ok := decl.(*dst.GenDecl)
 		if !ok || gd.Tok != token.TYPE {
 			continue
 		}
 		for _, spec := range gd.Specs {
-			if ts, ok := spec.(*dst.TypeSpec); ok {
-				c.typeNames[ts.Name.Name] = true
+			ts, ok := spec.(*dst.TypeSpec)
+			if !ok {
+				continue
 			}
+			c.typeNames[ts.Name.Name] = true
+			switch ts.Type.(type) {
+			case *dst.StructType:
+				structNames[ts.Name.Name] = true
+			case *dst.FuncType:
+				funcTypes = append(funcTypes, ts)
+			}
+		}
+	}
+
+	for _, ts := range funcTypes {
+		if structName := optionTargetName(ts); structNames[structName] {
+			c.optionTypes[ts.Name.Name] = structName
 		}
 	}
 }
 
-func (c *declCollector) sort() {
-	sortSpecsByExportabilityThenName(c.constSpecs)
-	sortSpecsByExportabilityThenName(c.varSpecs)
+// enumTypeName returns the local type of a constant spec if constants are laid
+// out after their type, and an empty string otherwise.
+func (c *declCollector) enumTypeName(spec dst.Spec) string {
+	if !c.enumLayout {
+		return ""
+	}
+	typeName := getConstTypeName(spec)
+	if !c.typeNames[typeName] {
+		return ""
+	}
+
+	return typeName
+}
 
-	for typeName := range c.constructors {
-		sortFuncDeclsByName(c.constructors[typeName])
+// optionTypesOf returns the option types configuring the struct, in name
+// order.
+func (c *declCollector) optionTypesOf(structName string) []string {
+	var optionTypes []string
+	for optionType, target := range c.optionTypes {
+		if target == structName {
+			optionTypes = append(optionTypes, optionType)
+		}
+	}
+	if c.stable {
+		declared := make(map[string]int)
+		for i, d := range c.typeDecls {
+			declared[declTypeName(d)] = i
+		}
+		sort.Slice(optionTypes, func(i, j int) bool {
+			return declared[optionTypes[i]] < declared[optionTypes[j]]
+		})
+	} else {
+		sort.Strings(optionTypes)
 	}
 
-	for typeName := range c.methodsByType {
-		sortFuncDeclsByExportabilityThenLayer(c.methodsByType[typeName])
+	return optionTypes
+}
+
+// sortVarBlock lays out a kept var block like the merged one: blank
+// identifiers, var groups, then the remaining specs.
+func (c *declCollector) sortVarBlock(block *dst.GenDecl) {
+	var blankVarSpecs, varSpecs []dst.Spec
+	groupedVarSpecs := make([][]dst.Spec, len(c.varGroups))
+	for _, spec := range block.Specs {
+		if isBlankVarSpec(spec) {
+			blankVarSpecs = append(blankVarSpecs, spec)
+		} else if i := matchVarGroup(spec, c.varGroups); i >= 0 {
+			groupedVarSpecs[i] = append(groupedVarSpecs[i], spec)
+		} else {
+			varSpecs = append(varSpecs, spec)
+		}
+	}
+	for _, specs := range groupedVarSpecs {
+		sortSpecsByExportabilityThenName(specs, c.stable)
+	}
+	sortSpecsByExportabilityThenName(varSpecs, c.stable)
+
+	var groupStarts []dst.Spec
+	block.Specs, groupStarts = orderVarSpecs(blankVarSpecs, groupedVarSpecs, varSpecs)
+	addEmptyLinesBetweenSpecGroups(block.Specs)
+	separateSpecGroups(groupStarts)
+}
+
+// typeAttachments returns the declarations laid out right after a type, before
+// its constructors, by type name.
+func (c *declCollector) typeAttachments() map[string][]dst.Decl {
+	attachments := make(map[string][]dst.Decl)
+	for typeName, decls := range c.enumIotaDecls {
+		for _, d := range decls {
+			attachments[typeName] = append(attachments[typeName], d)
+		}
+	}
+	for typeName, specs := range c.enumConstSpecs {
+		attachments[typeName] = append(attachments[typeName], mergeSpecsIntoBlock(token.CONST, specs))
+	}
+	for typeName, specs := range c.assertionSpecs {
+		attachments[typeName] = append(attachments[typeName], mergeSpecsIntoBlock(token.VAR, specs))
+	}
+
+	return attachments
+}
+
+// hasBlockHeader reports whether a parenthesised block of several specs carries
+// a comment of its own. Such a comment describes the whole block, so the block
+// is kept as a unit instead of scattering its specs.
+func hasBlockHeader(d *dst.GenDecl) bool {
+	if !d.Lparen || len(d.Specs) < 2 {
+		return false
+	}
+
+	return slices.ContainsFunc(slices.Concat(d.Decs.Start, d.Decs.Tok, d.Decs.Lparen), isCommentLine)
+}
+
+// hasGoDirective reports whether a declaration carries a //go: directive, which
+// only applies at column 0 and is therefore never merged into a block.
+func hasGoDirective(d *dst.GenDecl) bool {
+	return slices.ContainsFunc(d.Decs.Start, func(line string) bool {
+		return strings.HasPrefix(line, "//go:")
+	})
+}
+
+// moveDeclDecorationsToSpecs moves the comments and directives attached to a
+// const or var declaration onto its specs, since the declaration itself is
+// discarded when its specs are merged into a single block. The doc comment of
+// a standalone declaration or a block of one spec becomes the doc comment of
+// that spec.
+func moveDeclDecorationsToSpecs(d *dst.GenDecl) {
+	if len(d.Specs) == 0 {
+		return
+	}
+	first, ok := d.Specs[0].(*dst.ValueSpec)
+	if !ok {
+		return
 	}
+	last, ok := d.Specs[len(d.Specs)-1].(*dst.ValueSpec)
+	if !ok {
+		return
+	}
+
+	var header dst.Decorations
+	header = append(header, d.Decs.Start...)
+	header = append(header, d.Decs.Tok...)
+
+	// A comment separated from the first spec of a block by an empty line is
+	// attached after the opening paren, on its own line; keep it free-floating.
+	lparen := d.Decs.Lparen
+	for len(lparen) > 0 && lparen[0] == "\n" {
+		lparen = lparen[1:]
+	}
+	header = append(header, lparen...)
+	if len(lparen) > 0 && first.Decs.Before == dst.EmptyLine {
+		header = append(header, "\n")
+		first.Decs.Before = dst.NewLine
+	}
+	first.Decs.Start = append(header, first.Decs.Start...)
+	last.Decs.End = append(last.Decs.End, d.Decs.End...)
 
-	sortFuncDeclsByExportabilityThenLayer(c.orphanMethods)
+	d.Decs.Start = nil
+	d.Decs.Tok = nil
+	d.Decs.Lparen = nil
+	d.Decs.End = nil
+}
 
-	sortDeclsByExportabilityThenLayer(c.functions)
+// separateLparenComment keeps the empty line after a comment attached to the
+// opening paren of a block. Such a comment was followed by an empty line,
+// otherwise it would be the doc comment of the first spec.
+func separateLparenComment(d *dst.GenDecl) {
+	if len(d.Specs) == 0 || !slices.ContainsFunc(d.Decs.Lparen, isCommentLine) {
+		return
+	}
+	if first, ok := d.Specs[0].(*dst.ValueSpec); ok {
+		first.Decs.Before = dst.EmptyLine
+	}
 }
@@ -0,0 +1,216 @@
+package formatter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dave/dst"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileName is the name of the configuration file looked up in the
+// working directory.
+const ConfigFileName = ".wormatter.yaml"
+
+// Config is the content of the configuration file.
+type Config struct {
+	// ConstructorPatterns replaces DefaultConstructorPatterns.
+	ConstructorPatterns      []ConstructorPattern `yaml:"constructorPatterns"`
+	ConstructorsByReturnType bool                 `yaml:"constructorsByReturnType"`
+	FuncSort                 string               `yaml:"funcSort"`
+	InterfaceMethods         InterfaceMethods     `yaml:"interfaceMethods"`
+	InterfaceSort            string               `yaml:"interfaceSort"`
+	LayerCallsOnly           bool                 `yaml:"layerCallsOnly"`
+	// SectionOrder replaces DefaultSectionOrder.
+	SectionOrder []string `yaml:"sectionOrder"`
+	TestLayout   string   `yaml:"testLayout"`
+	// TypeOrder replaces DefaultTypeOrder.
+	TypeOrder []string   `yaml:"typeOrder"`
+	TypeSort  string     `yaml:"typeSort"`
+	VarGroups []VarGroup `yaml:"varGroups"`
+}
+
+// Apply sets the options configured in the file.
+func (c *Config) Apply(opts *Options) {
+	if c.ConstructorPatterns != nil {
+		opts.ConstructorPatterns = c.ConstructorPatterns
+	}
+	if c.ConstructorsByReturnType {
+		opts.ConstructorsByReturnType = true
+	}
+	if c.FuncSort != "" {
+		opts.FuncSort = c.FuncSort
+	}
+	if c.InterfaceMethods != (InterfaceMethods{}) {
+		opts.InterfaceMethods = c.InterfaceMethods
+	}
+	if c.InterfaceSort != "" {
+		opts.InterfaceSort = c.InterfaceSort
+	}
+	if c.LayerCallsOnly {
+		opts.LayerCallsOnly = true
+	}
+	if c.SectionOrder != nil {
+		opts.SectionOrder = c.SectionOrder
+	}
+	if c.TestLayout != "" {
+		opts.TestLayout = c.TestLayout
+	}
+	if c.TypeOrder != nil {
+		opts.TypeOrder = c.TypeOrder
+	}
+	if c.TypeSort != "" {
+		opts.TypeSort = c.TypeSort
+	}
+	if c.VarGroups != nil {
+		opts.VarGroups = c.VarGroups
+	}
+}
+
+func (c *Config) validate() error {
+	for i, p := range c.ConstructorPatterns {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("constructorPatterns[%d]: %w", i, err)
+		}
+	}
+	if c.SectionOrder != nil {
+		if err := validateSectionOrder(c.SectionOrder); err != nil {
+			return err
+		}
+	}
+	if err := validateFuncSort(c.FuncSort); err != nil {
+		return err
+	}
+	if err := c.InterfaceMethods.validate(); err != nil {
+		return err
+	}
+	if err := validateInterfaceSort(c.InterfaceSort); err != nil {
+		return err
+	}
+	if err := validateTestLayout(c.TestLayout); err != nil {
+		return err
+	}
+	if err := validateTypeSort(c.TypeSort); err != nil {
+		return err
+	}
+	if c.TypeOrder != nil {
+		if err := validateTypeOrder(c.TypeOrder); err != nil {
+			return err
+		}
+	}
+
+	for i, group := range c.VarGroups {
+		if group.Name == "" {
+			return fmt.Errorf("varGroups[%d]: name is required", i)
+		}
+		if len(group.Calls) == 0 && len(group.NamePrefixes) == 0 {
+			return fmt.Errorf("varGroups[%d] (%s): at least one of calls and namePrefixes is required", i, group.Name)
+		}
+	}
+
+	return nil
+}
+
+// VarGroup is a category of variables laid out as a separate group of the var
+// block. A variable belongs to the first group it matches any predicate of.
+type VarGroup struct {
+	// Calls matches variables initialized by a call to one of these functions,
+	// e.g. "errors.New".
+	Calls []string `yaml:"calls"`
+	Name  string   `yaml:"name"`
+	// NamePrefixes matches variables whose name is the prefix or starts with it
+	// followed by an uppercase letter: "Err" matches ErrNotFound, not Error.
+	NamePrefixes []string `yaml:"namePrefixes"`
+}
+
+func (g VarGroup) matches(vs *dst.ValueSpec) bool {
+	if len(vs.Names) > 0 {
+		name := vs.Names[0].Name
+		for _, prefix := range g.NamePrefixes {
+			if hasWordPrefix(name, prefix) {
+				return true
+			}
+		}
+	}
+
+	if len(vs.Values) > 0 {
+		if call, ok := vs.Values[0].(*dst.CallExpr); ok {
+			callName := callExprName(call)
+			for _, c := range g.Calls {
+				if callName == c {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// LoadConfig reads and validates the configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// callExprName returns the called function as written: "f" or "pkg.f".
+func callExprName(call *dst.CallExpr) string {
+	switch fun := call.Fun.(type) {
+	case *dst.Ident:
+		return fun.Name
+	case *dst.SelectorExpr:
+		if x, ok := fun.X.(*dst.Ident); ok {
+			return x.Name + "." + fun.Sel.Name
+		}
+	}
+
+	return ""
+}
+
+func hasWordPrefix(name, prefix string) bool {
+	if prefix == "" || !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+
+	return unicode.IsUpper(r)
+}
+
+// matchVarGroup returns the index of the first group matching the spec, or -1.
+func matchVarGroup(spec dst.Spec, groups []VarGroup) int {
+	vs, ok := spec.(*dst.ValueSpec)
+	if !ok {
+		return -1
+	}
+	for i, group := range groups {
+		if group.matches(vs) {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,227 @@
+package formatter
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/dave/dst"
+)
+
+// DefaultConstructorPatterns are the constructor patterns used if
+// Options.ConstructorPatterns is nil: NewT and newT.
+var DefaultConstructorPatterns = []ConstructorPattern{
+	{Prefix: "New"},
+	{Prefix: "new"},
+}
+
+// ConstructorPattern describes the name of functions grouped with the type they
+// return. With Prefix and Suffix, the name is the prefix, the type name (case
+// insensitive) and the suffix; without a suffix, the type name may be followed
+// by anything not starting with a lowercase letter (NewFooWithOptions). With
+// Regex, the named group "type" must match the type name.
+type ConstructorPattern struct {
+	Prefix string `yaml:"prefix"`
+	Regex  string `yaml:"regex"`
+	Suffix string `yaml:"suffix"`
+}
+
+func (p ConstructorPattern) validate() error {
+	if p.Regex == "" {
+		if p.Prefix == "" && p.Suffix == "" {
+			return fmt.Errorf("one of prefix, suffix and regex is required")
+		}
+
+		return nil
+	}
+
+	if p.Prefix != "" || p.Suffix != "" {
+		return fmt.Errorf("regex cannot be combined with prefix or suffix")
+	}
+	re, err := regexp.Compile(p.Regex)
+	if err != nil {
+		return err
+	}
+	if re.SubexpIndex("type") < 0 {
+		return fmt.Errorf("regex %q has no group named \"type\"", p.Regex)
+	}
+
+	return nil
+}
+
+// constructorMatcher finds the type a function constructs.
+type constructorMatcher struct {
+	// byReturnType matches any function returning exactly one local type.
+	byReturnType bool
+	// implementers maps local interfaces to their only local implementer.
+	implementers map[string]string
+	patterns     []ConstructorPattern
+	regexps      []*regexp.Regexp
+}
+
+func newConstructorMatcher(patterns []ConstructorPattern, byReturnType bool) *constructorMatcher {
+	if patterns == nil {
+		patterns = DefaultConstructorPatterns
+	}
+
+	m := &constructorMatcher{
+		byReturnType: byReturnType,
+		implementers: make(map[string]string),
+		patterns:     patterns,
+	}
+	for _, p := range patterns {
+		var re *regexp.Regexp
+		if p.Regex != "" {
+			re = regexp.MustCompile(p.Regex)
+		}
+		m.regexps = append(m.regexps, re)
+	}
+
+	return m
+}
+
+// findType returns the local type constructed by fn, or an empty string. A
+// returned interface with a single local implementer also stands for that
+// implementer.
+func (m *constructorMatcher) findType(fn *dst.FuncDecl, typeNames map[string]bool) string {
+	if fn.Type.Results == nil {
+		return ""
+	}
+
+	var candidates, returned []string
+	for _, result := range fn.Type.Results.List {
+		typeName := extractTypeName(result.Type)
+		if typeName == "" || !typeNames[typeName] {
+			continue
+		}
+		candidates = append(candidates, typeName)
+		if implementer, ok := m.implementers[typeName]; ok {
+			candidates = append(candidates, implementer)
+			typeName = implementer
+		}
+		if !slices.Contains(returned, typeName) {
+			returned = append(returned, typeName)
+		}
+	}
+
+	for _, typeName := range candidates {
+		if m.matchesName(fn.Name.Name, typeName) {
+			return typeName
+		}
+	}
+
+	if m.byReturnType && len(returned) == 1 {
+		return returned[0]
+	}
+
+	return ""
+}
+
+// collectImplementers records, for every local interface with only methods,
+// the local type implementing it if there is exactly one. Method sets are
+// compared by name.
+func (m *constructorMatcher) collectImplementers(f *dst.File) {
+	methods := make(map[string]map[string]bool)
+	interfaces := make(map[string][]string)
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *dst.FuncDecl:
+			if recvType := getReceiverTypeName(d); recvType != "" {
+				if methods[recvType] == nil {
+					methods[recvType] = make(map[string]bool)
+				}
+				methods[recvType][d.Name.Name] = true
+			}
+		case *dst.GenDecl:
+			for _, spec := range d.Specs {
+				ts, ok := spec.(*dst.TypeSpec)
+				if !ok {
+					continue
+				}
+				if iface, ok := ts.Type.(*dst.InterfaceType); ok {
+					if names, ok := interfaceMethodNames(iface); ok {
+						interfaces[ts.Name.Name] = names
+					}
+				}
+			}
+		}
+	}
+
+	for ifaceName, names := range interfaces {
+		var found []string
+		for typeName, typeMethods := range methods {
+			if implementsAll(typeMethods, names) {
+				found = append(found, typeName)
+			}
+		}
+		if len(found) == 1 {
+			m.implementers[ifaceName] = found[0]
+		}
+	}
+}
+
+func (m *constructorMatcher) matchesName(funcName, typeName string) bool {
+	for i, p := range m.patterns {
+		if re := m.regexps[i]; re != nil {
+			match := re.FindStringSubmatch(funcName)
+			if match != nil && strings.EqualFold(match[re.SubexpIndex("type")], typeName) {
+				return true
+			}
+
+			continue
+		}
+		if matchesAffixes(funcName, typeName, p.Prefix, p.Suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func implementsAll(methods map[string]bool, names []string) bool {
+	for _, name := range names {
+		if !methods[name] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// interfaceMethodNames returns the method names of an interface, and false if
+// it embeds other types, whose methods are not known.
+func interfaceMethodNames(iface *dst.InterfaceType) ([]string, bool) {
+	if iface.Methods == nil || len(iface.Methods.List) == 0 {
+		return nil, false
+	}
+
+	var names []string
+	for _, field := range iface.Methods.List {
+		if len(field.Names) == 0 {
+			return nil, false
+		}
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+
+	return names, true
+}
+
+func matchesAffixes(funcName, typeName, prefix, suffix string) bool {
+	if !strings.HasPrefix(funcName, prefix) || !strings.HasSuffix(funcName, suffix) || len(funcName) < len(prefix)+len(suffix) {
+		return false
+	}
+	middle := funcName[len(prefix) : len(funcName)-len(suffix)]
+
+	if strings.EqualFold(middle, typeName) {
+		return true
+	}
+	if suffix == "" && len(middle) > len(typeName) && strings.EqualFold(middle[:len(typeName)], typeName) {
+		return !unicode.IsLower(rune(middle[len(typeName)]))
+	}
+
+	return false
+}
@@ -0,0 +1,123 @@
+package formatter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// InternalError is a panic recovered while formatting a file or a package. It
+// always indicates a bug in the formatter.
+type InternalError struct {
+	// CrashReport is the path of the crash report written to Options.CrashDir:
+	// the copied input for a file, the report directory for a package.
+	CrashReport string
+	Pass        string
+	Path        string
+	Stack       []byte
+	Value       any
+
+	debug bool
+}
+
+func newInternalError(sf *sourceFile, pass string, value any, opts Options) *InternalError {
+	ierr := &InternalError{
+		Pass:  pass,
+		Path:  sf.path,
+		Stack: debug.Stack(),
+		Value: value,
+		debug: opts.Debug,
+	}
+
+	if opts.CrashDir != "" {
+		report, err := writeCrashReport(opts.CrashDir, sf.src, ierr)
+		if err != nil {
+			report = fmt.Sprintf("failed to write crash report: %v", err)
+		}
+		ierr.CrashReport = report
+	}
+
+	return ierr
+}
+
+func (e *InternalError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: internal error in %s: %v", e.Path, e.Pass, e.Value)
+	if e.CrashReport != "" {
+		fmt.Fprintf(&b, " (crash report: %s)", e.CrashReport)
+	}
+	if e.debug {
+		fmt.Fprintf(&b, "\n%s", e.Stack)
+	}
+
+	return b.String()
+}
+
+// newPackageInternalError is newInternalError for a panic in a step that works
+// on the whole package in dir, such as loading it or building its context.
+func newPackageInternalError(dir string, files []*sourceFile, pass string, value any, opts Options) *InternalError {
+	ierr := &InternalError{
+		Pass:  pass,
+		Path:  dir,
+		Stack: debug.Stack(),
+		Value: value,
+		debug: opts.Debug,
+	}
+
+	if opts.CrashDir != "" {
+		report, err := writePackageCrashReport(opts.CrashDir, files, ierr)
+		if err != nil {
+			report = fmt.Sprintf("failed to write crash report: %v", err)
+		}
+		ierr.CrashReport = report
+	}
+
+	return ierr
+}
+
+// writeCrashReport stores the input that caused the panic next to a text file
+// with the panic message and stack trace, and returns the path of the input.
+func writeCrashReport(dir string, src []byte, ierr *InternalError) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	base := fmt.Sprintf("%s-%s", strings.TrimSuffix(filepath.Base(ierr.Path), ".go"), time.Now().Format("20060102-150405.000000000"))
+	inputPath := filepath.Join(dir, base+".go.txt")
+	if err := os.WriteFile(inputPath, src, 0o644); err != nil {
+		return "", err
+	}
+
+	details := fmt.Sprintf("file: %s\npass: %s\npanic: %v\n\n%s", ierr.Path, ierr.Pass, ierr.Value, ierr.Stack)
+	if err := os.WriteFile(filepath.Join(dir, base+".panic.txt"), []byte(details), 0o644); err != nil {
+		return "", err
+	}
+
+	return inputPath, nil
+}
+
+// writePackageCrashReport stores the inputs of every loaded file of the package
+// in a new directory, next to a text file with the panic message and stack
+// trace, and returns the path of the directory.
+func writePackageCrashReport(dir string, files []*sourceFile, ierr *InternalError) (string, error) {
+	reportDir := filepath.Join(dir, fmt.Sprintf("%s-%s", filepath.Base(ierr.Path), time.Now().Format("20060102-150405.000000000")))
+	if err := os.MkdirAll(reportDir, 0o755); err != nil {
+		return "", err
+	}
+
+	for _, sf := range files {
+		if err := os.WriteFile(filepath.Join(reportDir, filepath.Base(sf.path)+".txt"), sf.src, 0o644); err != nil {
+			return "", err
+		}
+	}
+
+	details := fmt.Sprintf("package: %s\npass: %s\npanic: %v\n\n%s", ierr.Path, ierr.Pass, ierr.Value, ierr.Stack)
+	if err := os.WriteFile(filepath.Join(reportDir, "panic.txt"), []byte(details), 0o644); err != nil {
+		return "", err
+	}
+
+	return reportDir, nil
+}
@@ -0,0 +1,138 @@
+package formatter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatParsedFileInternalError(t *testing.T) {
+	src := []byte("package main\n\nfunc main() {}\n")
+	tests := []struct {
+		debug bool
+		name  string
+	}{
+		{name: "report"},
+		{debug: true, name: "debug"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "main.go")
+			if err := os.WriteFile(filePath, src, 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			f, err := parseSource(filePath, src)
+			if err != nil {
+				t.Fatalf("failed to parse file: %v", err)
+			}
+			// A nil declaration makes the first pass walking the file panic.
+			f.Decls = append(f.Decls, nil)
+
+			crashDir := filepath.Join(dir, "crashes")
+			sf := &sourceFile{file: f, path: filePath, src: src, writable: true}
+			formatted, err := formatParsedFile(sf, Options{CrashDir: crashDir, Debug: tt.debug})
+			if formatted != nil {
+				t.Errorf("no output should be returned, got:\n%s", formatted)
+			}
+
+			var ierr *InternalError
+			if !errors.As(err, &ierr) {
+				t.Fatalf("expected an *InternalError, got: %v", err)
+			}
+			if ierr.Pass != "collapseFuncSignatures" || ierr.Path != filePath {
+				t.Errorf("unexpected pass %q or path %q", ierr.Pass, ierr.Path)
+			}
+			if hasStack := strings.Contains(err.Error(), "goroutine "); hasStack != tt.debug {
+				t.Errorf("stack trace should be printed only in debug mode, got: %v", err)
+			}
+
+			actualBytes, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatalf("failed to read actual file: %v", err)
+			}
+			if string(actualBytes) != string(src) {
+				t.Errorf("file should not be written, got:\n%s", actualBytes)
+			}
+
+			entries, err := os.ReadDir(crashDir)
+			if err != nil {
+				t.Fatalf("failed to read crash dir: %v", err)
+			}
+			if len(entries) != 2 {
+				t.Fatalf("crash dir should hold the input and the panic details, got %d files", len(entries))
+			}
+			if !strings.HasPrefix(filepath.Base(ierr.CrashReport), "main-") || !strings.HasSuffix(ierr.CrashReport, ".go.txt") {
+				t.Errorf("unexpected crash report path %q", ierr.CrashReport)
+			}
+
+			input, err := os.ReadFile(ierr.CrashReport)
+			if err != nil {
+				t.Fatalf("failed to read crash report: %v", err)
+			}
+			if string(input) != string(src) {
+				t.Errorf("crash report should hold the input, got:\n%s", input)
+			}
+
+			details, err := os.ReadFile(strings.TrimSuffix(ierr.CrashReport, ".go.txt") + ".panic.txt")
+			if err != nil {
+				t.Fatalf("failed to read panic details: %v", err)
+			}
+			if !strings.HasPrefix(string(details), "file: "+filePath+"\npass: collapseFuncSignatures\npanic: ") {
+				t.Errorf("unexpected panic details:\n%s", details)
+			}
+		})
+	}
+}
+
+func TestNewPackageInternalError(t *testing.T) {
+	dir := t.TempDir()
+	pkgDir := filepath.Join(dir, "geo")
+	crashDir := filepath.Join(dir, "crashes")
+	files := []*sourceFile{
+		{path: filepath.Join(pkgDir, "point.go"), src: []byte("package geo\n\ntype Point struct{}\n")},
+		{path: filepath.Join(pkgDir, "use.go"), src: []byte("package geo\n\nvar origin = Point{}\n")},
+	}
+
+	ierr := newPackageInternalError(pkgDir, files, "buildPackageContexts", "boom", Options{CrashDir: crashDir})
+	if ierr.Pass != "buildPackageContexts" || ierr.Path != pkgDir {
+		t.Errorf("unexpected pass %q or path %q", ierr.Pass, ierr.Path)
+	}
+	if filepath.Dir(ierr.CrashReport) != crashDir || !strings.HasPrefix(filepath.Base(ierr.CrashReport), "geo-") {
+		t.Errorf("unexpected crash report path %q", ierr.CrashReport)
+	}
+
+	entries, err := os.ReadDir(ierr.CrashReport)
+	if err != nil {
+		t.Fatalf("failed to read crash report: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, " ") != "panic.txt point.go.txt use.go.txt" {
+		t.Errorf("crash report should hold every file and the panic details, got: %v", names)
+	}
+
+	for _, sf := range files {
+		input, err := os.ReadFile(filepath.Join(ierr.CrashReport, filepath.Base(sf.path)+".txt"))
+		if err != nil {
+			t.Fatalf("failed to read crash report: %v", err)
+		}
+		if string(input) != string(sf.src) {
+			t.Errorf("crash report should hold the input of %s, got:\n%s", sf.path, input)
+		}
+	}
+
+	details, err := os.ReadFile(filepath.Join(ierr.CrashReport, "panic.txt"))
+	if err != nil {
+		t.Fatalf("failed to read panic details: %v", err)
+	}
+	if !strings.HasPrefix(string(details), "package: "+pkgDir+"\npass: buildPackageContexts\npanic: boom\n") {
+		t.Errorf("unexpected panic details:\n%s", details)
+	}
+}
@@ -1,27 +1,153 @@
 package formatter
 
 import (
+	"fmt"
 	"go/token"
+	"strings"
 
 	"github.com/dave/dst"
+	"github.com/samber/lo"
 )
 
-func reorderDeclarations(f *dst.File) []dst.Decl {
-	c := newDeclCollector()
+const (
+	// Orders of standalone functions and methods. See Options.FuncSort.
+
+	// FuncSortLayers places exported functions first, then sorts by
+	// architectural layer, higher layers first.
+	FuncSortLayers = "layers"
+
+	// FuncSortStepDown follows every function by the functions it calls, in
+	// order of first call, starting from the exported functions.
+	FuncSortStepDown = "stepDown"
+
+	// Orders of interface members. See Options.InterfaceSort.
+
+	// InterfaceSortAlphabetical moves embedded interfaces and type-set terms
+	// first and sorts methods within every group separated by blank lines,
+	// exported first, then by name.
+	InterfaceSortAlphabetical = "alphabetical"
+
+	// InterfaceSortMembers moves embedded interfaces, sorted by name, and
+	// type-set terms before the methods, which keep their order.
+	InterfaceSortMembers = "members"
+
+	// InterfaceSortOriginal keeps the original order.
+	InterfaceSortOriginal = "original"
+
+	// Sections of a file. See Options.SectionOrder.
+
+	SectionConsts        = "consts"
+	SectionFunctions     = "functions"
+	SectionImports       = "imports"
+	SectionInit          = "init"
+	SectionIotaConsts    = "iotaConsts"
+	SectionMain          = "main"
+	SectionOrphanMethods = "orphanMethods"
+	SectionTypes         = "types"
+	SectionVars          = "vars"
+
+	// Categories of types. See Options.TypeOrder.
+
+	TypeCategoryAliases        = "aliases"
+	TypeCategoryConstraints    = "constraints"
+	TypeCategoryFuncInterfaces = "funcInterfaces"
+	TypeCategoryInterfaces     = "interfaces"
+	TypeCategorySimple         = "simple"
+	TypeCategoryStructs        = "structs"
+
+	// Orders of types within a category. See Options.TypeSort.
+
+	// TypeSortAlphabetical sorts types by name.
+	TypeSortAlphabetical = "alphabetical"
+
+	// TypeSortDependency places types before the types they use, top-down.
+	TypeSortDependency = "dependency"
+
+	// TypeSortOriginal keeps the original order.
+	TypeSortOriginal = "original"
+
+	// TypeSortPrimary moves the type named after the file (server.go: Server)
+	// first and keeps the original order otherwise.
+	TypeSortPrimary = "primary"
+)
+
+var (
+	// DefaultSectionOrder is the order of the file-level sections used if
+	// Options.SectionOrder is nil.
+	DefaultSectionOrder = []string{
+		SectionImports,
+		SectionInit,
+		SectionConsts,
+		SectionIotaConsts,
+		SectionVars,
+		SectionTypes,
+		SectionOrphanMethods,
+		SectionFunctions,
+		SectionMain,
+	}
+
+	// DefaultTypeOrder is the order of the type categories used if
+	// Options.TypeOrder is nil. Aliases and constraint interfaces are not
+	// categories of their own unless TypeCategoryAliases and
+	// TypeCategoryConstraints are listed in the order.
+	DefaultTypeOrder = []string{
+		TypeCategorySimple,
+		TypeCategoryFuncInterfaces,
+		TypeCategoryInterfaces,
+		TypeCategoryStructs,
+	}
+)
+
+// typeUnit is a type followed by the declarations laid out with it.
+type typeUnit struct {
+	decls []dst.Decl
+	name  string
+}
+
+// reorderDeclarations lays out the declarations of f. packageLayers, if not nil,
+// are the architectural layers of the functions of the whole package, and
+// packageTypes the declaration order of its types.
+func reorderDeclarations(f *dst.File, filePath string, packageLayers, packageTypes map[string]int, opts Options) []dst.Decl {
+	c := newDeclCollector(filePath, packageLayers, packageTypes, opts)
 	c.collect(f)
 	c.sort()
 
-	var result []dst.Decl
+	sectionOrder := opts.SectionOrder
+	if sectionOrder == nil {
+		sectionOrder = DefaultSectionOrder
+	}
+
+	units := typeUnits(c)
+	functions := c.functions
+	if c.testLayout != "" {
+		functions, units = layoutTestFunctions(c.testFuncs, c.functions, units)
+	}
 
-	result = append(result, c.imports...)
-	result = appendInitFuncs(result, c.initFuncs)
-	result = appendConstBlock(result, c.constSpecs)
-	result = appendIotaConstBlocks(result, c.iotaConstDecls)
-	result = appendVarBlock(result, c.blankVarSpecs, c.varSpecs)
-	result = appendTypesWithMethods(result, c.typeDecls, c.constructors, c.methodsByType)
-	result = appendOrphanMethods(result, c.orphanMethods)
-	result = appendFunctions(result, c.functions)
-	result = appendMainFunc(result, c.mainFunc)
+	var result []dst.Decl
+	for _, section := range sectionOrder {
+		switch section {
+		case SectionImports:
+			result = append(result, c.imports...)
+		case SectionInit:
+			result = appendInitFuncs(result, c.initFuncs)
+		case SectionConsts:
+			result = appendConstBlock(result, c.constSpecs)
+			result = appendKeptBlocks(result, c.constBlocks)
+		case SectionIotaConsts:
+			result = appendIotaConstBlocks(result, c.iotaConstDecls)
+		case SectionVars:
+			result = appendVarBlock(result, c.blankVarSpecs, c.groupedVarSpecs, c.varSpecs)
+			result = appendKeptBlocks(result, c.varBlocks)
+		case SectionTypes:
+			result = appendTypeUnits(result, units)
+		case SectionOrphanMethods:
+			result = appendOrphanMethods(result, c.orphanMethods)
+		case SectionFunctions:
+			result = appendFunctions(result, functions)
+		case SectionMain:
+			result = appendMainFunc(result, c.mainFunc)
+		}
+	}
 
 	return result
 }
@@ -38,12 +164,16 @@ func appendConstBlock(result []dst.Decl, constSpecs []dst.Spec) []dst.Decl {
 	return append(result, constDecl)
 }
 
-func appendVarBlock(result []dst.Decl, blankVarSpecs, varSpecs []dst.Spec) []dst.Decl {
-	allVarSpecs := append(blankVarSpecs, varSpecs...)
+// appendVarBlock merges the var specs into one block: blank identifiers first,
+// then every var group, then the remaining specs. Each group is separated by an
+// empty line.
+func appendVarBlock(result []dst.Decl, blankVarSpecs []dst.Spec, groupedVarSpecs [][]dst.Spec, varSpecs []dst.Spec) []dst.Decl {
+	allVarSpecs, groupStarts := orderVarSpecs(blankVarSpecs, groupedVarSpecs, varSpecs)
 	if len(allVarSpecs) == 0 {
 		return result
 	}
 	varDecl := mergeSpecsIntoBlock(token.VAR, allVarSpecs)
+	separateSpecGroups(groupStarts)
 	if len(result) > 0 {
 		varDecl.Decs.Before = dst.EmptyLine
 	}
@@ -62,32 +192,13 @@ func appendFunctions(result, functions []dst.Decl) []dst.Decl {
 	return result
 }
 
-func appendTypesWithMethods(result []dst.Decl, typeDecls []*dst.GenDecl, constructors, methodsByType map[string][]*dst.FuncDecl) []dst.Decl {
-	splitTypes := splitAndGroupTypeDecls(typeDecls)
-
-	for i, typeDecl := range splitTypes {
-		if i == 0 && len(result) > 0 {
-			setDeclSpacing(typeDecl, dst.EmptyLine)
-		}
-		result = append(result, typeDecl)
-
-		gd, ok := typeDecl.(*dst.GenDecl)
-		if !ok || len(gd.Specs) != 1 {
-			continue
-		}
-		ts, ok := gd.Specs[0].(*dst.TypeSpec)
-		if !ok {
-			continue
-		}
-
-		typeName := ts.Name.Name
-		for _, c := range constructors[typeName] {
-			c.Decs.Before = dst.EmptyLine
-			result = append(result, c)
-		}
-		for _, m := range methodsByType[typeName] {
-			m.Decs.Before = dst.EmptyLine
-			result = append(result, m)
+func appendTypeUnits(result []dst.Decl, units []typeUnit) []dst.Decl {
+	for _, unit := range units {
+		for _, d := range unit.decls {
+			if len(result) > 0 {
+				setDeclSpacing(d, dst.EmptyLine)
+			}
+			result = append(result, d)
 		}
 	}
 
@@ -101,12 +212,48 @@ func mergeSpecsIntoBlock(tok token.Token, specs []dst.Spec) *dst.GenDecl {
 	}
 	if len(specs) > 1 {
 		gd.Lparen = true
+		gd.Rparen = true
 		addEmptyLinesBetweenSpecGroups(specs)
+	} else if vs, ok := specs[0].(*dst.ValueSpec); ok && len(vs.Decs.Start) > 0 {
+		// Without parentheses, the doc comment of the spec would be printed
+		// after the keyword.
+		gd.Decs.Before = dst.EmptyLine
+		gd.Decs.Start = vs.Decs.Start
+		vs.Decs.Start = nil
 	}
 
 	return gd
 }
 
+// validateSectionOrder checks that order lists every section exactly once,
+// starting with the imports.
+func validateSectionOrder(order []string) error {
+	if err := validateOrder("section order", order, DefaultSectionOrder); err != nil {
+		return err
+	}
+
+	if order[0] != SectionImports {
+		return fmt.Errorf("section order: %q must be first", SectionImports)
+	}
+
+	return nil
+}
+
+// validateTypeOrder checks that order lists every category of
+// DefaultTypeOrder exactly once, and the opt-in categories at most once.
+func validateTypeOrder(order []string) error {
+	var required []string
+	for _, category := range order {
+		if category != TypeCategoryAliases && category != TypeCategoryConstraints {
+			required = append(required, category)
+		} else if lo.Count(order, category) > 1 {
+			return fmt.Errorf("type order: duplicate item %q", category)
+		}
+	}
+
+	return validateOrder("type order", required, DefaultTypeOrder)
+}
+
 func addEmptyLinesBetweenSpecGroups(specs []dst.Spec) {
 	var lastGroup int
 	var lastType string
@@ -119,7 +266,7 @@ func addEmptyLinesBetweenSpecGroups(specs []dst.Spec) {
 		currentType := getSpecTypeName(spec)
 		if i == 0 {
 			vs.Decs.Before = dst.NewLine
-		} else if currentGroup != lastGroup {
+		} else if currentGroup != lastGroup || hasDocComment(vs.Decs.Start) {
 			vs.Decs.Before = dst.EmptyLine
 		} else if currentGroup != 0 && currentType != lastType {
 			vs.Decs.Before = dst.EmptyLine
@@ -152,6 +299,18 @@ func appendIotaConstBlocks(result []dst.Decl, iotaConstDecls []*dst.GenDecl) []d
 	return result
 }
 
+// appendKeptBlocks appends the original const or var blocks kept as units.
+func appendKeptBlocks(result []dst.Decl, blocks []*dst.GenDecl) []dst.Decl {
+	for _, block := range blocks {
+		if len(result) > 0 {
+			block.Decs.Before = dst.EmptyLine
+		}
+		result = append(result, block)
+	}
+
+	return result
+}
+
 func appendMainFunc(result []dst.Decl, mainFunc *dst.FuncDecl) []dst.Decl {
 	if mainFunc == nil {
 		return result
@@ -172,6 +331,28 @@ func appendOrphanMethods(result []dst.Decl, orphanMethods []*dst.FuncDecl) []dst
 	return result
 }
 
+// orderVarSpecs concatenates the parts of a var block and returns the first
+// spec of every non-empty part.
+func orderVarSpecs(blankVarSpecs []dst.Spec, groupedVarSpecs [][]dst.Spec, varSpecs []dst.Spec) ([]dst.Spec, []dst.Spec) {
+	var allVarSpecs, groupStarts []dst.Spec
+	for _, specs := range append(append([][]dst.Spec{blankVarSpecs}, groupedVarSpecs...), varSpecs) {
+		if len(specs) > 0 {
+			groupStarts = append(groupStarts, specs[0])
+			allVarSpecs = append(allVarSpecs, specs...)
+		}
+	}
+
+	return allVarSpecs, groupStarts
+}
+
+func separateSpecGroups(groupStarts []dst.Spec) {
+	for i, spec := range groupStarts {
+		if i > 0 {
+			spec.(*dst.ValueSpec).Decs.Before = dst.EmptyLine
+		}
+	}
+}
+
 func setDeclSpacing(decl dst.Decl, spacing dst.SpaceType) {
 	switch d := decl.(type) {
 	case *dst.GenDecl:
@@ -180,3 +361,120 @@ func setDeclSpacing(decl dst.Decl, spacing dst.SpaceType) {
 		d.Decs.Before = spacing
 	}
 }
+
+// typeUnits returns every type followed by the declarations attached to it,
+// its constructors and its methods. A struct configured by functional options
+// is followed by its constructors, then every option type with the functions
+// returning it, then its methods.
+func typeUnits(c *declCollector) []typeUnit {
+	var splitTypes []dst.Decl
+	if c.testLayout == "" {
+		splitTypes = splitAndGroupTypeDecls(c.typeDecls, c.typeOrdering)
+	} else {
+		// The types placed before their first user are sorted apart from the
+		// others, so that taking them out leaves the rest in order.
+		names := referencedNames(c.testFuncs, c.functions)
+		claimed, rest := lo.FilterReject(splitTypeDecls(c.typeDecls), func(gd *dst.GenDecl, _ int) bool {
+			return names[declTypeName(gd)]
+		})
+		splitTypes = append(splitAndGroupTypeDecls(rest, c.typeOrdering), splitAndGroupTypeDecls(claimed, c.typeOrdering)...)
+	}
+	attachments := c.typeAttachments()
+
+	optionDecls := make(map[string]dst.Decl)
+	var typeDecls []dst.Decl
+	for _, typeDecl := range splitTypes {
+		if typeName := declTypeName(typeDecl); c.optionTypes[typeName] != "" {
+			optionDecls[typeName] = typeDecl
+		} else {
+			typeDecls = append(typeDecls, typeDecl)
+		}
+	}
+
+	units := make([]typeUnit, 0, len(typeDecls))
+	for _, typeDecl := range typeDecls {
+		unit := typeUnit{decls: []dst.Decl{typeDecl}, name: declTypeName(typeDecl)}
+		if unit.name == "" {
+			units = append(units, unit)
+			continue
+		}
+
+		unit.decls = append(unit.decls, attachments[unit.name]...)
+		for _, fn := range c.constructors[unit.name] {
+			unit.decls = append(unit.decls, fn)
+		}
+		for _, optionType := range c.optionTypesOf(unit.name) {
+			unit.decls = append(unit.decls, optionDecls[optionType])
+			unit.decls = append(unit.decls, attachments[optionType]...)
+			for _, fn := range c.optionFuncs[optionType] {
+				unit.decls = append(unit.decls, fn)
+			}
+			for _, fn := range c.constructors[optionType] {
+				unit.decls = append(unit.decls, fn)
+			}
+			for _, fn := range c.methodsByType[optionType] {
+				unit.decls = append(unit.decls, fn)
+			}
+		}
+		for _, fn := range c.methodsByType[unit.name] {
+			unit.decls = append(unit.decls, fn)
+		}
+		units = append(units, unit)
+	}
+
+	return units
+}
+
+func validateFuncSort(funcSort string) error {
+	switch funcSort {
+	case "", FuncSortLayers, FuncSortStepDown:
+		return nil
+	}
+
+	return fmt.Errorf("func sort: unknown value %q (known: %s, %s)", funcSort, FuncSortLayers, FuncSortStepDown)
+}
+
+func validateInterfaceSort(interfaceSort string) error {
+	switch interfaceSort {
+	case "", InterfaceSortAlphabetical, InterfaceSortMembers, InterfaceSortOriginal:
+		return nil
+	}
+
+	return fmt.Errorf("interface sort: unknown value %q (known: %s, %s, %s)", interfaceSort, InterfaceSortOriginal, InterfaceSortMembers, InterfaceSortAlphabetical)
+}
+
+// validateOrder checks that order is a permutation of known.
+func validateOrder(what string, order, known []string) error {
+	isKnown := make(map[string]bool)
+	for _, item := range known {
+		isKnown[item] = true
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range order {
+		if !isKnown[item] {
+			return fmt.Errorf("%s: unknown item %q (known: %s)", what, item, strings.Join(known, ", "))
+		}
+		if seen[item] {
+			return fmt.Errorf("%s: duplicate item %q", what, item)
+		}
+		seen[item] = true
+	}
+
+	for _, item := range known {
+		if !seen[item] {
+			return fmt.Errorf("%s: missing item %q", what, item)
+		}
+	}
+
+	return nil
+}
+
+func validateTypeSort(typeSort string) error {
+	switch typeSort {
+	case "", TypeSortAlphabetical, TypeSortDependency, TypeSortOriginal, TypeSortPrimary:
+		return nil
+	}
+
+	return fmt.Errorf("type sort: unknown value %q (known: %s, %s, %s, %s)", typeSort, TypeSortOriginal, TypeSortAlphabetical, TypeSortPrimary, TypeSortDependency)
+}
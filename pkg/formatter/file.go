@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/dave/dst"
 	"github.com/dave/dst/decorator"
 	"mvdan.cc/gofumpt/format"
 )
@@ -18,87 +19,335 @@ import (
 var ErrNeedsFormatting = errors.New("file needs formatting")
 
 type Options struct {
-	CheckOnly       bool
+	// AttachAssertions places blank identifier assertions whose value references
+	// a type declared in the file (var _ I = (*T)(nil)) right after that type.
+	AttachAssertions bool
+	CheckOnly        bool
+	// ConstructorPatterns are the names of functions grouped with the type they
+	// return. If nil, DefaultConstructorPatterns are used.
+	ConstructorPatterns []ConstructorPattern
+	// ConstructorsByReturnType also groups any function returning exactly one
+	// type declared in the file with that type.
+	ConstructorsByReturnType bool
+	// CrashDir, if set, receives a copy of every input that makes the formatter
+	// panic, together with the panic message and stack trace.
+	CrashDir string
+	// Debug includes stack traces in internal error messages.
+	Debug bool
+	// EnumLayout places iota blocks and constants whose type is declared in the
+	// file right after that type, before its constructors and methods.
+	EnumLayout      bool
 	ExcludePatterns []string
+	// FuncSort is the order of standalone functions and of the methods of a type
+	// (see the FuncSort constants). If empty, FuncSortLayers is used.
+	FuncSort string
+	// InterfaceMethods groups the methods of a type by the interfaces it
+	// implements. Disabled by default.
+	InterfaceMethods InterfaceMethods
+	// InterfaceSort is the order of the members of interface types (see the
+	// InterfaceSort constants). If empty, the original order is kept.
+	InterfaceSort string
+	// KeepBlocks keeps every parenthesised const and var block as a unit, sorted
+	// internally. Only standalone declarations are merged.
+	KeepBlocks bool
+	// LayerCallsOnly limits the call graph used to compute architectural layers
+	// to calls. By default, any reference to a local function is an edge.
+	LayerCallsOnly bool
+	// MoveOrphanMethods moves methods whose receiver type is declared in another
+	// file of the package into that file, with the imports they use. Requires
+	// PackageMode.
+	MoveOrphanMethods bool
+	// PackageLayers computes architectural layers from the call graph of the
+	// whole package instead of the file being formatted. Functions are still only
+	// reordered within their file. Requires PackageMode.
+	PackageLayers bool
+	// PackageLayersTests includes test files in the package call graph. Without
+	// it, test files are layered on their own.
+	PackageLayersTests bool
+	// PackageMode loads all files of a package together, so that struct literals
+	// are converted and reordered consistently across files.
+	PackageMode bool
+	// SectionOrder is the order of the file-level sections (see the Section
+	// constants). It must list every section once, imports first. If nil,
+	// DefaultSectionOrder is used.
+	SectionOrder []string
+	// Stable keeps the relative order of declarations, specs and fields that
+	// belong to the same group, so that only what violates a layout rule moves.
+	// Alphabetical and layer ordering within groups is skipped. Orders selected
+	// explicitly with FuncSort, InterfaceSort, TestLayout and TypeSort still
+	// apply.
+	Stable bool
+	// TestLayout lays out test files in the order go test and godoc present
+	// them (see the TestLayout constants). If empty, test files are laid out
+	// like other files.
+	TestLayout string
+	// TypeAware resolves composite literal types with the type checker, so that
+	// literals of structs from other packages are ordered and keyed as well.
+	TypeAware bool
+	// TypeOrder is the order of the type categories (see the TypeCategory
+	// constants). It must list every category of DefaultTypeOrder once; aliases
+	// and constraints are separate categories only if listed. If nil,
+	// DefaultTypeOrder is used.
+	TypeOrder []string
+	// TypeSort is the order of types within a category (see the TypeSort
+	// constants). If empty, the original order is kept.
+	TypeSort string
+	// VarGroups are the categories of variables laid out as separate groups at
+	// the top of the var block, in order. If empty, variables are not grouped.
+	VarGroups []VarGroup
+	// Verify compares the original and formatted ASTs, normalised into a canonical
+	// declaration, field and keyed-literal order, and fails if anything else differs.
+	Verify bool
+	// VerifyIdempotent formats every file twice in memory and reports files
+	// where the second pass changes the output. Files are not written.
+	VerifyIdempotent bool
 }
 
-func FormatDirectory(dir string, opts Options) error {
-	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
+func (o Options) validate() error {
+	for i, p := range o.ConstructorPatterns {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("constructor pattern %d: %w", i, err)
+		}
+	}
+	if o.MoveOrphanMethods && !o.PackageMode {
+		return errors.New("moving orphan methods requires package mode")
+	}
+	if o.PackageLayers && !o.PackageMode {
+		return errors.New("package layers require package mode")
+	}
+	if o.PackageLayersTests && !o.PackageLayers {
+		return errors.New("package layers tests require package layers")
+	}
+	if o.SectionOrder != nil {
+		if err := validateSectionOrder(o.SectionOrder); err != nil {
 			return err
 		}
-		if !d.IsDir() && strings.HasSuffix(path, ".go") {
-			if matchesAnyPattern(path, opts.ExcludePatterns) {
-				return nil
+	}
+	if err := validateFuncSort(o.FuncSort); err != nil {
+		return err
+	}
+	if err := o.InterfaceMethods.validate(); err != nil {
+		return err
+	}
+	if err := validateInterfaceSort(o.InterfaceSort); err != nil {
+		return err
+	}
+	if err := validateTestLayout(o.TestLayout); err != nil {
+		return err
+	}
+	if err := validateTypeSort(o.TypeSort); err != nil {
+		return err
+	}
+	if o.TypeOrder != nil {
+		if err := validateTypeOrder(o.TypeOrder); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sourceFile is a parsed file together with what is known about it beyond its
+// own syntax.
+type sourceFile struct {
+	// baseline is the source the formatted file is verified against if
+	// declarations were moved from or into the file. Otherwise it is nil and
+	// src is used.
+	baseline []byte
+	file     *dst.File
+	literals literalTypes
+	path     string
+	pkg      *packageContext
+	src      []byte
+	writable bool
+}
+
+// FormatDirectory formats all Go files under dir. A file that fails to format
+// does not stop the walk: all errors are returned joined once every file has
+// been processed.
+func FormatDirectory(dir string, opts Options) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	var errs []error
+
+	var err error
+	if opts.PackageMode || opts.TypeAware {
+		err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
 			}
-			if err := FormatFile(path, opts); err != nil {
+			if d.IsDir() {
+				if err := formatPackage(path, "", opts); err != nil {
+					errs = append(errs, err)
+				}
+			}
+
+			return nil
+		})
+	} else {
+		err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
 				return err
 			}
-		}
+			if !d.IsDir() && strings.HasSuffix(path, ".go") {
+				if matchesAnyPattern(path, opts.ExcludePatterns) {
+					return nil
+				}
+				if err := FormatFile(path, opts); err != nil {
+					errs = append(errs, err)
+				}
+			}
 
-		return nil
-	})
+			return nil
+		})
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
 }
 
 func FormatFile(filePath string, opts Options) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
 	if matchesAnyPattern(filePath, opts.ExcludePatterns) {
 		return nil
 	}
 
-	fset := token.NewFileSet()
-	f, err := decorator.ParseFile(fset, filePath, nil, parser.ParseComments)
+	if opts.PackageMode || opts.TypeAware {
+		return formatPackage(filepath.Dir(filePath), filePath, opts)
+	}
+
+	original, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	formatted, err := formatSource(filePath, original, opts)
 	if err != nil {
 		return err
 	}
 
+	return writeFormatted(filePath, original, formatted, opts)
+}
+
+func formatSource(filePath string, src []byte, opts Options) ([]byte, error) {
+	f, err := parseSource(filePath, src)
+	if err != nil {
+		return nil, err
+	}
+
 	if isGeneratedFile(f) {
-		return nil
+		return src, nil
 	}
 
+	return formatParsedFile(&sourceFile{file: f, path: filePath, src: src, writable: true}, opts)
+}
+
+// formatParsedFile formats an already parsed file. With a package context,
+// struct definitions are taken from the whole package instead of the file.
+// Literals resolved by the type checker are converted and reordered first.
+// A panic in any pass is returned as an *InternalError.
+func formatParsedFile(sf *sourceFile, opts Options) (formatted []byte, err error) {
+	f, filePath, pkg := sf.file, sf.path, sf.pkg
+
+	var pass string
+	defer func() {
+		if r := recover(); r != nil {
+			err = newInternalError(sf, pass, r, opts)
+		}
+	}()
+
+	var typedFieldNames [][]string
+	if opts.Verify && sf.literals != nil {
+		typedFieldNames = collectTypedFieldNames(f, sf.literals)
+	}
+
+	pass = "collapseFuncSignatures"
 	collapseFuncSignatures(f)
-	originalFieldOrder := collectOriginalFieldOrder(f)
+	var originalFieldOrder, sortedFieldOrder map[string][]string
+	var pinnedStructs map[string]bool
+	var packageLayers, packageTypes map[string]int
+	if pkg == nil {
+		originalFieldOrder = collectOriginalFieldOrder(f)
+	} else {
+		originalFieldOrder, sortedFieldOrder, pinnedStructs = pkg.structsOf(sf)
+		packageLayers = pkg.layersOf(filePath)
+		packageTypes = pkg.typeOrder
+	}
+	if sf.literals != nil {
+		pass = "applyLiteralTypes"
+		applyLiteralTypes(sf.literals, pinnedStructs)
+	}
+	pass = "convertPositionalToKeyed"
 	convertPositionalToKeyed(f, originalFieldOrder)
-	reorderStructFields(f)
-	sortedFieldOrder := collectStructDefinitions(f)
+	pass = "reorderStructFields"
+	reorderStructFields(f, pinnedStructs, opts.Stable)
+	if pkg == nil {
+		sortedFieldOrder = collectStructDefinitions(f, opts.Stable)
+	}
+	pass = "reorderInterfaceMembers"
+	reorderInterfaceMembers(f, opts.InterfaceSort)
+	pass = "reorderStructLiterals"
 	reorderStructLiterals(f, sortedFieldOrder)
-	f.Decls = reorderDeclarations(f)
+	pass = "reorderDeclarations"
+	f.Decls = reorderDeclarations(f, filePath, packageLayers, packageTypes, opts)
+	pass = "normalizeSpacing"
 	normalizeSpacing(f)
+	pass = "expandOneLineFunctions"
 	expandOneLineFunctions(f)
+	pass = "addSpaceBeforeReturns"
 	addSpaceBeforeReturns(f)
+	pass = "addSpaceBeforeComments"
 	addSpaceBeforeComments(f)
+	pass = "removeBlankLinesBetweenCases"
 	removeBlankLinesBetweenCases(f)
 
+	pass = "print"
 	var buf bytes.Buffer
 	if err := decorator.Fprint(&buf, f); err != nil {
-		return err
+		return nil, fmt.Errorf("%s: print: %w", filePath, err)
 	}
 
-	formatted, err := format.Source(buf.Bytes(), format.Options{
+	pass = "gofumpt"
+	formatted, err = format.Source(buf.Bytes(), format.Options{
 		LangVersion: detectGoVersion(filePath),
 		ExtraRules:  true,
 	})
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%s: gofumpt: %w", filePath, err)
 	}
 
+	pass = "formatImports"
 	formatted, err = formatImports(filePath, formatted)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%s: format imports: %w", filePath, err)
 	}
 
-	if opts.CheckOnly {
-		original, err := os.ReadFile(filePath)
-		if err != nil {
-			return err
-		}
-		if !bytes.Equal(original, formatted) {
-			return fmt.Errorf("%s: %w", filePath, ErrNeedsFormatting)
+	if opts.VerifyIdempotent {
+		pass = "verifyIdempotent"
+		if err := verifyIdempotent(sf, formatted, opts); err != nil {
+			return nil, err
 		}
+	}
 
-		return nil
+	if opts.Verify {
+		pass = "verifyEquivalence"
+		original := sf.src
+		if sf.baseline != nil {
+			original = sf.baseline
+		}
+		if err := verifyEquivalence(filePath, original, formatted, originalFieldOrder, typedFieldNames); err != nil {
+			return nil, err
+		}
 	}
 
-	return os.WriteFile(filePath, formatted, 0o644)
+	return formatted, nil
 }
 
 func matchesAnyPattern(path string, patterns []string) bool {
@@ -113,3 +362,28 @@ func matchesAnyPattern(path string, patterns []string) bool {
 
 	return false
 }
+
+func parseSource(filePath string, src []byte) (*dst.File, error) {
+	f, err := decorator.ParseFile(token.NewFileSet(), filePath, src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse: %w", err)
+	}
+
+	return f, nil
+}
+
+func writeFormatted(filePath string, original, formatted []byte, opts Options) error {
+	if opts.CheckOnly {
+		if !bytes.Equal(original, formatted) {
+			return fmt.Errorf("%s: %w", filePath, ErrNeedsFormatting)
+		}
+
+		return nil
+	}
+
+	if opts.VerifyIdempotent || bytes.Equal(original, formatted) {
+		return nil
+	}
+
+	return os.WriteFile(filePath, formatted, 0o644)
+}
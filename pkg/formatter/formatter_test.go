@@ -3,6 +3,7 @@ package formatter_test
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/werf/wormatter/pkg/formatter"
@@ -172,3 +173,2652 @@ var x = 1
 		t.Error("excluded file should not be modified")
 	}
 }
+
+func TestFormatterVerify(t *testing.T) {
+	actualPath := "testdata/verify.go"
+	content := `package main
+
+import "fmt"
+
+func (s *Server) run(
+	name string,
+	port int,
+) {
+	fmt.Println(s.name, name, port)
+}
+
+type Server struct {
+	port int
+	name string
+}
+
+func newServer() *Server { return &Server{8080, "srv"} }
+
+// Config is documented.
+type Config struct{ B, A int }
+
+var cfg = Config{B: 1, A: 2}
+
+const mode = 0755
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	defer os.Remove(actualPath)
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{Verify: true}); err != nil {
+		t.Fatalf("verification failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) == content {
+		t.Error("file should be formatted after successful verification")
+	}
+}
+
+func TestFormatterPackageMode(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"point.go": `package geo
+
+type Point struct {
+	Y int
+	X int
+}
+
+type Pinned struct {
+	B int
+	A int
+}
+`,
+		"use.go": `package geo
+
+var origin = Point{1, 2}
+`,
+		"use_test.go": `package geo
+
+var points = []Point{{3, 4}}
+`,
+		"pinned_gen.go": `// Code generated by some tool. DO NOT EDIT.
+
+package geo
+
+var pinned = Pinned{1, 2}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	if err := formatter.FormatDirectory(dir, formatter.Options{PackageMode: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"point.go": `package geo
+
+type Point struct {
+	X int
+	Y int
+}
+
+type Pinned struct {
+	B int
+	A int
+}
+`,
+		"use.go": `package geo
+
+var origin = Point{X: 2, Y: 1}
+`,
+		"use_test.go": `package geo
+
+var points = []Point{{X: 4, Y: 3}}
+`,
+		"pinned_gen.go": files["pinned_gen.go"],
+	}
+	for name, want := range expected {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s does not match expected.\n\nActual:\n%s\n\nExpected:\n%s", name, got, want)
+		}
+	}
+}
+
+func TestFormatterPackageModeBuildConstraints(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"point_linux.go": `package geo
+
+type Point struct {
+	Y int
+	X int
+}
+
+var origin = Point{1, 2}
+`,
+		"point_windows.go": `package geo
+
+type Point struct {
+	Y int
+	X int
+}
+`,
+		"use_windows.go": `// Code generated by some tool. DO NOT EDIT.
+
+package geo
+
+var origin = Point{1, 2}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	if err := formatter.FormatDirectory(dir, formatter.Options{PackageMode: true, Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"point_linux.go": `package geo
+
+var origin = Point{X: 2, Y: 1}
+
+type Point struct {
+	X int
+	Y int
+}
+`,
+		"point_windows.go": files["point_windows.go"],
+		"use_windows.go":   files["use_windows.go"],
+	}
+	for name, want := range expected {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s does not match expected.\n\nActual:\n%s\n\nExpected:\n%s", name, got, want)
+		}
+	}
+}
+
+func TestFormatterPackageModeUnparsableFile(t *testing.T) {
+	const (
+		point = `package geo
+
+type Point struct {
+	Y int
+	X int
+}
+`
+		broken = `package geo
+
+var p = Point{1, 2}
+
+func broken() {
+`
+	)
+
+	tests := []struct {
+		excludePatterns []string
+		name            string
+		wantErr         bool
+	}{
+		{
+			name:    "reported",
+			wantErr: true,
+		},
+		{
+			excludePatterns: []string{"broken.go"},
+			name:            "excluded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			pointPath := filepath.Join(dir, "point.go")
+			brokenPath := filepath.Join(dir, "broken.go")
+			if err := os.WriteFile(pointPath, []byte(point), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			if err := os.WriteFile(brokenPath, []byte(broken), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			err := formatter.FormatDirectory(dir, formatter.Options{ExcludePatterns: tt.excludePatterns, PackageMode: true})
+			if tt.wantErr && (err == nil || !strings.Contains(err.Error(), brokenPath)) {
+				t.Errorf("error should mention %s, got: %v", brokenPath, err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			actualBytes, err := os.ReadFile(pointPath)
+			if err != nil {
+				t.Fatalf("failed to read actual file: %v", err)
+			}
+			if string(actualBytes) != point {
+				t.Errorf("struct fields should keep their order, got:\n%s", actualBytes)
+			}
+		})
+	}
+}
+
+func TestFormatterTypeAware(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/typed\n\ngo 1.22\n",
+		"typed.go": `package typed
+
+import "image"
+
+type Pair[T any] struct {
+	Second T
+	First  T
+}
+
+type Ints = Pair[int]
+
+var (
+	bounds = image.Rectangle{Max: image.Point{3, 4}, Min: image.Point{1, 2}}
+	ints   = Ints{1, 2}
+)
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	if err := formatter.FormatDirectory(dir, formatter.Options{TypeAware: true, Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	expected := `package typed
+
+import "image"
+
+var (
+	bounds = image.Rectangle{Max: image.Point{X: 3, Y: 4}, Min: image.Point{X: 1, Y: 2}}
+	ints   = Ints{First: 2, Second: 1}
+)
+
+type Ints = Pair[int]
+
+type Pair[T any] struct {
+	First  T
+	Second T
+}
+`
+
+	actualBytes, err := os.ReadFile(filepath.Join(dir, "typed.go"))
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("formatted output does not match expected.\n\nActual:\n%s\n\nExpected:\n%s", actualBytes, expected)
+	}
+}
+
+func TestFormatterVerifyIdempotent(t *testing.T) {
+	testdataDir := "testdata"
+	inputPath := filepath.Join(testdataDir, "input.go")
+	actualPath := filepath.Join(testdataDir, "verify_idempotent.go")
+
+	inputBytes, err := os.ReadFile(inputPath)
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+
+	if err := os.WriteFile(actualPath, inputBytes, 0o644); err != nil {
+		t.Fatalf("failed to write actual file: %v", err)
+	}
+	defer os.Remove(actualPath)
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{VerifyIdempotent: true}); err != nil {
+		t.Fatalf("idempotency check failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != string(inputBytes) {
+		t.Error("idempotency check should not modify the file")
+	}
+}
+
+func TestFormatterContinuesAfterError(t *testing.T) {
+	tests := []struct {
+		name string
+		opts formatter.Options
+	}{
+		{name: "file"},
+		{name: "package", opts: formatter.Options{PackageMode: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			brokenPath := filepath.Join(dir, "broken.go")
+			goodPath := filepath.Join(dir, "good.go")
+
+			if err := os.WriteFile(brokenPath, []byte("package main\n\nfunc broken() {\n"), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			if err := os.WriteFile(goodPath, []byte("package main\n\nfunc main() {}\nvar x = 1\n"), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			err := formatter.FormatDirectory(dir, tt.opts)
+			if err == nil {
+				t.Fatal("expected an error for the broken file")
+			}
+			if !strings.Contains(err.Error(), brokenPath) {
+				t.Errorf("error should mention %s, got: %v", brokenPath, err)
+			}
+
+			actualBytes, err := os.ReadFile(goodPath)
+			if err != nil {
+				t.Fatalf("failed to read actual file: %v", err)
+			}
+
+			expected := "package main\n\nvar x = 1\n\nfunc main() {}\n"
+			if string(actualBytes) != expected {
+				t.Errorf("good file should be formatted, got:\n%s", actualBytes)
+			}
+		})
+	}
+}
+
+func TestFormatterPreservesDeclComments(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "comments.go")
+	content := `package main
+
+// MaxRetries controls how often a request is retried.
+const MaxRetries = 3 // attempts
+
+// Limits.
+const (
+	ZMax = 10
+	AMin = 1
+)
+
+//go:generate echo generate
+var b = 2
+var a = 1
+
+// Sep separates fields.
+var Sep = ","
+`
+	expected := `package main
+
+// MaxRetries controls how often a request is retried.
+const MaxRetries = 3 // attempts
+
+// Limits.
+const (
+	AMin = 1
+	ZMax = 10
+)
+
+var (
+	// Sep separates fields.
+	Sep = ","
+
+	a = 1
+)
+
+//go:generate echo generate
+var b = 2
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("comments should be moved onto the specs, got:\n%s", actualBytes)
+	}
+
+	single := "package main\n\n// Version is the release.\nvar Version = \"1.0\"\n"
+	if err := os.WriteFile(actualPath, []byte(single), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err = os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != single {
+		t.Errorf("doc comment of a single declaration should stay in place, got:\n%s", actualBytes)
+	}
+
+	section := "package main\n\nconst (\n\t// Limits.\n\n\t// MaxSize is the largest size.\n\tMaxSize = 10\n\tMinSize = 1\n)\n"
+	if err := os.WriteFile(actualPath, []byte(section), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err = os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != section {
+		t.Errorf("free-floating comment of a block should stay separate from the first doc comment, got:\n%s", actualBytes)
+	}
+}
+
+func TestFormatterEnumLayout(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "enum.go")
+	content := `package main
+
+const (
+	PriorityLow Priority = iota
+	PriorityHigh
+)
+
+const StatusOK Status = "ok"
+const Version = "1.0"
+
+func (p Priority) String() string { return "" }
+
+type Priority int
+
+const StatusError Status = "error"
+
+type Status string
+`
+	expected := `package main
+
+const Version = "1.0"
+
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	return ""
+}
+
+type Status string
+
+const (
+	StatusError Status = "error"
+	StatusOK    Status = "ok"
+)
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{EnumLayout: true, Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("constants should follow their type, got:\n%s", actualBytes)
+	}
+}
+
+func TestFormatterAttachAssertions(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "assertions.go")
+	content := `package main
+
+import (
+	"fmt"
+	"io"
+)
+
+var _ io.Reader = (*Server)(nil)
+var _ fmt.Stringer = (*Server)(nil)
+var _ io.Writer = io.Discard
+
+func NewServer() *Server { return &Server{} }
+
+type Server struct{}
+`
+	expected := `package main
+
+import (
+	"fmt"
+	"io"
+)
+
+var _ io.Writer = io.Discard
+
+type Server struct{}
+
+var (
+	_ io.Reader    = (*Server)(nil)
+	_ fmt.Stringer = (*Server)(nil)
+)
+
+func NewServer() *Server {
+	return &Server{}
+}
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{AttachAssertions: true, Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("assertions should follow their type, got:\n%s", actualBytes)
+	}
+
+	option := `package main
+
+type applier interface{ apply(*Server) }
+
+var _ applier = Option(nil)
+
+type Option func(*Server)
+
+func (o Option) apply(s *Server) { o(s) }
+
+func WithName(name string) Option {
+	return func(s *Server) { s.name = name }
+}
+
+type Server struct{ name string }
+`
+	expectedOption := `package main
+
+type applier interface{ apply(*Server) }
+
+type Server struct {
+	name string
+}
+
+type Option func(*Server)
+
+var _ applier = Option(nil)
+
+func WithName(name string) Option {
+	return func(s *Server) { s.name = name }
+}
+
+func (o Option) apply(s *Server) {
+	o(s)
+}
+`
+
+	if err := os.WriteFile(actualPath, []byte(option), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{AttachAssertions: true, Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err = os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expectedOption {
+		t.Errorf("assertions should follow their option type, got:\n%s", actualBytes)
+	}
+}
+
+func TestFormatterVarGroups(t *testing.T) {
+	dir := t.TempDir()
+	actualPath := filepath.Join(dir, "vars.go")
+	configPath := filepath.Join(dir, formatter.ConfigFileName)
+	content := `package main
+
+import (
+	"errors"
+	"regexp"
+)
+
+var registry = map[string]int{}
+var ErrNotFound = errors.New("not found")
+var ErrorCount = 0
+var nameRe = regexp.MustCompile("x")
+`
+	config := `varGroups:
+  - name: errors
+    namePrefixes: [Err]
+  - name: registries
+    namePrefixes: [registry]
+`
+	expected := `package main
+
+import (
+	"errors"
+	"regexp"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+
+	registry = map[string]int{}
+
+	ErrorCount = 0
+
+	nameRe = regexp.MustCompile("x")
+)
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := formatter.LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	opts := formatter.Options{Verify: true}
+	cfg.Apply(&opts)
+
+	if err := formatter.FormatFile(actualPath, opts); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("vars should be grouped by the configured groups, got:\n%s", actualBytes)
+	}
+
+	if err := os.WriteFile(configPath, []byte("varGroups:\n  - name: empty\n"), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if _, err := formatter.LoadConfig(configPath); err == nil {
+		t.Error("a var group without predicates should be rejected")
+	}
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := formatter.FormatFile(actualPath, formatter.Options{Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err = os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	ungrouped := `package main
+
+import (
+	"errors"
+	"regexp"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrorCount  = 0
+
+	nameRe   = regexp.MustCompile("x")
+	registry = map[string]int{}
+)
+`
+	if string(actualBytes) != ungrouped {
+		t.Errorf("vars should not be grouped without configured groups, got:\n%s", actualBytes)
+	}
+}
+
+func TestFormatterSectionOrder(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "sections.go")
+	content := `package main
+
+import "fmt"
+
+func init() { fmt.Println(name) }
+
+type Config struct{}
+
+var name = "app"
+
+func helper() {}
+`
+	expected := `package main
+
+import "fmt"
+
+var name = "app"
+
+func init() {
+	fmt.Println(name)
+}
+
+func helper() {}
+
+type Config struct{}
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	opts := formatter.Options{
+		SectionOrder: []string{
+			formatter.SectionImports,
+			formatter.SectionConsts,
+			formatter.SectionIotaConsts,
+			formatter.SectionVars,
+			formatter.SectionInit,
+			formatter.SectionFunctions,
+			formatter.SectionTypes,
+			formatter.SectionOrphanMethods,
+			formatter.SectionMain,
+		},
+		Verify: true,
+	}
+	if err := formatter.FormatFile(actualPath, opts); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("sections should follow the configured order, got:\n%s", actualBytes)
+	}
+
+	opts.SectionOrder = []string{formatter.SectionImports, formatter.SectionTypes}
+	if err := formatter.FormatFile(actualPath, opts); err == nil {
+		t.Error("an incomplete section order should be rejected")
+	}
+}
+
+func TestFormatterKeepBlocks(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "blocks.go")
+	content := `package main
+
+var single = 1
+
+// HTTP defaults.
+const (
+	Timeout = 30
+	Port    = 80
+)
+
+const Solo = 1
+
+// Feature flags.
+var (
+	enableZ = true
+	enableA = false
+)
+
+var other = 2
+`
+	expected := `package main
+
+const Solo = 1
+
+// HTTP defaults.
+const (
+	Port    = 80
+	Timeout = 30
+)
+
+var (
+	other  = 2
+	single = 1
+)
+
+// Feature flags.
+var (
+	enableA = false
+	enableZ = true
+)
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{KeepBlocks: true, Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("parenthesised blocks should be kept, got:\n%s", actualBytes)
+	}
+}
+
+func TestFormatterTypeCategories(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "types.go")
+	content := `package main
+
+type Pair struct{}
+
+type ID = string
+
+type Kind int
+
+type Number interface {
+	~int | ~float64
+}
+`
+
+	tests := []struct {
+		expected  string
+		name      string
+		typeOrder []string
+	}{
+		{
+			name: "default",
+			expected: `package main
+
+type ID = string
+
+type Kind int
+
+type Number interface {
+	~int | ~float64
+}
+
+type Pair struct{}
+`,
+		},
+		{
+			name: "configured",
+			typeOrder: []string{
+				formatter.TypeCategorySimple,
+				formatter.TypeCategoryStructs,
+				formatter.TypeCategoryFuncInterfaces,
+				formatter.TypeCategoryInterfaces,
+				formatter.TypeCategoryConstraints,
+				formatter.TypeCategoryAliases,
+			},
+			expected: `package main
+
+type Kind int
+
+type Pair struct{}
+
+type Number interface {
+	~int | ~float64
+}
+
+type ID = string
+`,
+		},
+		{
+			name: "aliases only",
+			typeOrder: []string{
+				formatter.TypeCategorySimple,
+				formatter.TypeCategoryFuncInterfaces,
+				formatter.TypeCategoryInterfaces,
+				formatter.TypeCategoryStructs,
+				formatter.TypeCategoryAliases,
+			},
+			expected: `package main
+
+type Kind int
+
+type Number interface {
+	~int | ~float64
+}
+
+type Pair struct{}
+
+type ID = string
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			if err := formatter.FormatFile(actualPath, formatter.Options{TypeOrder: tt.typeOrder, Verify: true}); err != nil {
+				t.Fatalf("formatter failed: %v", err)
+			}
+
+			actualBytes, err := os.ReadFile(actualPath)
+			if err != nil {
+				t.Fatalf("failed to read actual file: %v", err)
+			}
+
+			if string(actualBytes) != tt.expected {
+				t.Errorf("types should follow the category order, got:\n%s", actualBytes)
+			}
+		})
+	}
+}
+
+func TestFormatterTypeSort(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "server.go")
+	content := `package main
+
+type Options struct{ Retry Retry }
+
+type Retry struct{}
+
+type Handler struct{}
+
+type Server struct {
+	Handler *Handler
+	Options Options
+}
+`
+
+	tests := []struct {
+		expected []string
+		typeSort string
+	}{
+		{typeSort: formatter.TypeSortOriginal, expected: []string{"Options", "Retry", "Handler", "Server"}},
+		{typeSort: formatter.TypeSortAlphabetical, expected: []string{"Handler", "Options", "Retry", "Server"}},
+		{typeSort: formatter.TypeSortPrimary, expected: []string{"Server", "Options", "Retry", "Handler"}},
+		{typeSort: formatter.TypeSortDependency, expected: []string{"Server", "Options", "Retry", "Handler"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typeSort, func(t *testing.T) {
+			if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			if err := formatter.FormatFile(actualPath, formatter.Options{TypeSort: tt.typeSort, Verify: true}); err != nil {
+				t.Fatalf("formatter failed: %v", err)
+			}
+
+			actualBytes, err := os.ReadFile(actualPath)
+			if err != nil {
+				t.Fatalf("failed to read actual file: %v", err)
+			}
+
+			var names []string
+			for _, line := range strings.Split(string(actualBytes), "\n") {
+				if name, ok := strings.CutPrefix(line, "type "); ok {
+					names = append(names, strings.Fields(name)[0])
+				}
+			}
+			if strings.Join(names, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("expected types %v, got %v", tt.expected, names)
+			}
+		})
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{TypeSort: "random"}); err == nil {
+		t.Error("an unknown type sort should be rejected")
+	}
+}
+
+func TestFormatterConstructorPatterns(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "constructors.go")
+	content := `package main
+
+func ParseFoo(s string) (*Foo, error) { return nil, nil }
+
+func FooFromConfig() Foo { return Foo{} }
+
+func openStore() Store { return &memStore{} }
+
+func helper() {}
+
+type Store interface{ Get() string }
+
+type Foo struct{}
+
+type memStore struct{}
+
+func (m *memStore) Get() string { return "" }
+`
+	expected := `package main
+
+type Store interface{ Get() string }
+
+type Foo struct{}
+
+func FooFromConfig() Foo {
+	return Foo{}
+}
+
+func ParseFoo(s string) (*Foo, error) {
+	return nil, nil
+}
+
+type memStore struct{}
+
+func openStore() Store {
+	return &memStore{}
+}
+
+func (m *memStore) Get() string {
+	return ""
+}
+
+func helper() {}
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	opts := formatter.Options{
+		ConstructorPatterns: []formatter.ConstructorPattern{
+			{Prefix: "New"},
+			{Regex: `^Parse(?P<type>\w+)$`},
+			{Suffix: "FromConfig"},
+		},
+		ConstructorsByReturnType: true,
+		Verify:                   true,
+	}
+	if err := formatter.FormatFile(actualPath, opts); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("factories should be grouped with their type, got:\n%s", actualBytes)
+	}
+
+	opts.ConstructorPatterns = []formatter.ConstructorPattern{{Regex: "^Parse"}}
+	if err := formatter.FormatFile(actualPath, opts); err == nil {
+		t.Error("a regex without a type group should be rejected")
+	}
+}
+
+func TestFormatterFunctionalOptions(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "options.go")
+	content := `package main
+
+func WithTimeout(d int) Option { return func(s *Server) { s.timeout = d } }
+
+func (s *Server) Run() {}
+
+type Option func(*Server)
+
+func NewServer(opts ...Option) *Server { return &Server{} }
+
+func WithAddr(a string) Option { return func(s *Server) { s.addr = a } }
+
+type Server struct {
+	addr    string
+	timeout int
+}
+`
+	expected := `package main
+
+type Server struct {
+	addr    string
+	timeout int
+}
+
+func NewServer(opts ...Option) *Server {
+	return &Server{}
+}
+
+type Option func(*Server)
+
+func WithAddr(a string) Option {
+	return func(s *Server) { s.addr = a }
+}
+
+func WithTimeout(d int) Option {
+	return func(s *Server) { s.timeout = d }
+}
+
+func (s *Server) Run() {}
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("functional options should follow the constructors, got:\n%s", actualBytes)
+	}
+}
+
+func TestFormatterMethodLayers(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "server.go")
+	content := `package main
+
+type Server struct {
+	handlers map[string]func()
+}
+
+func (s *Server) apply() {}
+
+func (s *Server) handle() { s.apply() }
+
+func (s *Server) check() bool { return true }
+
+func (s *Server) register() {
+	if s.check() {
+		s.handlers["x"] = s.handle
+	}
+}
+`
+	expected := `package main
+
+type Server struct {
+	handlers map[string]func()
+}
+
+func (s *Server) register() {
+	if s.check() {
+		s.handlers["x"] = s.handle
+	}
+}
+
+func (s *Server) handle() {
+	s.apply()
+}
+
+func (s *Server) apply() {}
+
+func (s *Server) check() bool {
+	return true
+}
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("methods should be ordered by their calls through the receiver, got:\n%s", actualBytes)
+	}
+}
+
+func TestFormatterFunctionReferenceLayers(t *testing.T) {
+	content := `package main
+
+func index() { render() }
+
+func render() {}
+
+func handle(path string, h func()) {}
+
+func routes() {
+	handle("/", index)
+}
+`
+	tests := []struct {
+		expected string
+		name     string
+		opts     formatter.Options
+	}{
+		{
+			name: "references",
+			expected: `package main
+
+func routes() {
+	handle("/", index)
+}
+
+func index() {
+	render()
+}
+
+func handle(path string, h func()) {}
+
+func render() {}
+`,
+		},
+		{
+			name: "calls only",
+			opts: formatter.Options{LayerCallsOnly: true},
+			expected: `package main
+
+func index() {
+	render()
+}
+
+func routes() {
+	handle("/", index)
+}
+
+func handle(path string, h func()) {}
+
+func render() {}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actualPath := filepath.Join(t.TempDir(), "main.go")
+			if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			tt.opts.Verify = true
+			if err := formatter.FormatFile(actualPath, tt.opts); err != nil {
+				t.Fatalf("formatter failed: %v", err)
+			}
+
+			actualBytes, err := os.ReadFile(actualPath)
+			if err != nil {
+				t.Fatalf("failed to read actual file: %v", err)
+			}
+
+			if string(actualBytes) != tt.expected {
+				t.Errorf("unexpected layer order, got:\n%s", actualBytes)
+			}
+		})
+	}
+}
+
+func TestFormatterShadowedFunctionLayers(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "main.go")
+	content := `package main
+
+func render() {}
+
+func total(index []int) (n int) {
+	for _, render := range index {
+		n += render
+	}
+
+	return n
+}
+
+func index() {
+	render := "page"
+	_ = render
+}
+`
+	expected := `package main
+
+func index() {
+	render := "page"
+	_ = render
+}
+
+func render() {}
+
+func total(index []int) (n int) {
+	for _, render := range index {
+		n += render
+	}
+
+	return n
+}
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("locals shadowing functions should not be references, got:\n%s", actualBytes)
+	}
+}
+
+func TestFormatterPackageLayers(t *testing.T) {
+	files := map[string]string{
+		"app.go": `package app
+
+func a() {}
+
+func b() { helper() }
+`,
+		"helper.go": `package app
+
+func helper() { util() }
+
+func util() {}
+`,
+		"app_test.go": `package app
+
+func c() {}
+
+func d() { helper() }
+`,
+	}
+	tests := []struct {
+		expected map[string]string
+		name     string
+		opts     formatter.Options
+	}{
+		{
+			name: "without tests",
+			opts: formatter.Options{PackageLayers: true, PackageMode: true},
+			expected: map[string]string{
+				"app.go": `package app
+
+func b() {
+	helper()
+}
+
+func a() {}
+`,
+				"app_test.go": `package app
+
+func c() {}
+
+func d() {
+	helper()
+}
+`,
+			},
+		},
+		{
+			name: "with tests",
+			opts: formatter.Options{PackageLayers: true, PackageLayersTests: true, PackageMode: true},
+			expected: map[string]string{
+				"app.go": `package app
+
+func b() {
+	helper()
+}
+
+func a() {}
+`,
+				"app_test.go": `package app
+
+func d() {
+	helper()
+}
+
+func c() {}
+`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+
+			if err := formatter.FormatDirectory(dir, tt.opts); err != nil {
+				t.Fatalf("formatter failed: %v", err)
+			}
+
+			for name, want := range tt.expected {
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatalf("failed to read %s: %v", name, err)
+				}
+				if string(got) != want {
+					t.Errorf("%s does not match expected.\n\nActual:\n%s\n\nExpected:\n%s", name, got, want)
+				}
+			}
+		})
+	}
+
+	if err := formatter.FormatDirectory(t.TempDir(), formatter.Options{PackageLayers: true}); err == nil {
+		t.Error("package layers without package mode should fail")
+	}
+}
+
+func TestFormatterFuncSortStepDown(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "main.go")
+	content := `package main
+
+type Server struct{}
+
+func (s *Server) flush() {}
+
+func (s *Server) Close() { s.flush() }
+
+func (s *Server) Start() { s.listen() }
+
+func (s *Server) listen() { s.accept() }
+
+func (s *Server) accept() {}
+
+func helperB() {}
+
+func Run() {
+	prepare()
+	finish()
+}
+
+func finish() { helperB() }
+
+func prepare() { helperA() }
+
+func helperA() {}
+
+func Stop() { finish() }
+`
+	expected := `package main
+
+type Server struct{}
+
+func (s *Server) Close() {
+	s.flush()
+}
+
+func (s *Server) flush() {}
+
+func (s *Server) Start() {
+	s.listen()
+}
+
+func (s *Server) listen() {
+	s.accept()
+}
+
+func (s *Server) accept() {}
+
+func Run() {
+	prepare()
+	finish()
+}
+
+func prepare() {
+	helperA()
+}
+
+func helperA() {}
+
+func finish() {
+	helperB()
+}
+
+func helperB() {}
+
+func Stop() {
+	finish()
+}
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{FuncSort: formatter.FuncSortStepDown, Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("functions should follow the step-down rule, got:\n%s", actualBytes)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{FuncSort: "random"}); err == nil {
+		t.Error("unknown func sort should fail")
+	}
+}
+
+func TestInspectLayers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.go")
+	content := `package main
+
+type Server struct{}
+
+func (s *Server) Run() { s.loop() }
+
+func (s *Server) loop() { s.step() }
+
+func (s *Server) step() { s.loop() }
+
+func Main() { ping() }
+
+func ping() { pong() }
+
+func pong() { ping() }
+
+func helper() {}
+
+func run() { helper() }
+
+func Start() { stop() }
+
+func stop() { Start() }
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	layers, err := formatter.InspectLayers(path, formatter.Options{})
+	if err != nil {
+		t.Fatalf("inspect failed: %v", err)
+	}
+
+	tests := []struct {
+		expected string
+		format   string
+	}{
+		{
+			expected: `# exported functions
+Main   layer 0  PATH  calls ping
+Start  layer 0  PATH  calls stop  cycle Start, stop
+
+# unexported functions
+run     layer 1  PATH  calls helper
+helper  layer 0  PATH
+ping    layer 0  PATH  calls pong   cycle ping, pong
+pong    layer 0  PATH  calls ping   cycle ping, pong
+stop    layer 0  PATH  calls Start  cycle Start, stop
+
+# exported methods of Server
+Server.Run  layer 0  PATH  calls Server.loop
+
+# unexported methods of Server
+Server.loop  layer 0  PATH  calls Server.step  cycle Server.loop, Server.step
+Server.step  layer 0  PATH  calls Server.loop  cycle Server.loop, Server.step
+`,
+			format: formatter.LayersFormatText,
+		},
+		{
+			expected: `digraph layers {
+	subgraph cluster_0 {
+		label="exported functions";
+		g0_Main [label="Main\nlayer 0"];
+		g0_Start [label="Start\nlayer 0"];
+	}
+	subgraph cluster_1 {
+		label="unexported functions";
+		g1_run [label="run\nlayer 1"];
+		g1_helper [label="helper\nlayer 0"];
+		g1_ping [label="ping\nlayer 0"];
+		g1_pong [label="pong\nlayer 0"];
+		g1_stop [label="stop\nlayer 0"];
+		g1_run -> g1_helper;
+		g1_ping -> g1_pong;
+		g1_pong -> g1_ping;
+	}
+	subgraph cluster_2 {
+		label="exported methods of Server";
+		g2_Server_Run [label="Server.Run\nlayer 0"];
+	}
+	subgraph cluster_3 {
+		label="unexported methods of Server";
+		g3_Server_loop [label="Server.loop\nlayer 0"];
+		g3_Server_step [label="Server.step\nlayer 0"];
+		g3_Server_loop -> g3_Server_step;
+		g3_Server_step -> g3_Server_loop;
+	}
+	g0_Main -> g1_ping;
+	g0_Start -> g1_stop;
+	g1_stop -> g0_Start;
+	g2_Server_Run -> g3_Server_loop;
+}
+`,
+			format: formatter.LayersFormatDOT,
+		},
+		{
+			expected: `flowchart TD
+	subgraph g0 ["exported functions"]
+		g0_Main["Main (layer 0)"]
+		g0_Start["Start (layer 0)"]
+	end
+	subgraph g1 ["unexported functions"]
+		g1_run["run (layer 1)"]
+		g1_helper["helper (layer 0)"]
+		g1_ping["ping (layer 0)"]
+		g1_pong["pong (layer 0)"]
+		g1_stop["stop (layer 0)"]
+		g1_run --> g1_helper
+		g1_ping --> g1_pong
+		g1_pong --> g1_ping
+	end
+	subgraph g2 ["exported methods of Server"]
+		g2_Server_Run["Server.Run (layer 0)"]
+	end
+	subgraph g3 ["unexported methods of Server"]
+		g3_Server_loop["Server.loop (layer 0)"]
+		g3_Server_step["Server.step (layer 0)"]
+		g3_Server_loop --> g3_Server_step
+		g3_Server_step --> g3_Server_loop
+	end
+	g0_Main --> g1_ping
+	g0_Start --> g1_stop
+	g1_stop --> g0_Start
+	g2_Server_Run --> g3_Server_loop
+`,
+			format: formatter.LayersFormatMermaid,
+		},
+		{
+			expected: `[
+  {
+    "callees": [
+      "ping"
+    ],
+    "file": "PATH",
+    "group": "exported functions",
+    "layer": 0,
+    "name": "Main"
+  },
+  {
+    "callees": [
+      "stop"
+    ],
+    "cycle": [
+      "Start",
+      "stop"
+    ],
+    "file": "PATH",
+    "group": "exported functions",
+    "layer": 0,
+    "name": "Start"
+  },
+  {
+    "callees": [
+      "helper"
+    ],
+    "file": "PATH",
+    "group": "unexported functions",
+    "layer": 1,
+    "name": "run"
+  },
+  {
+    "callees": [],
+    "file": "PATH",
+    "group": "unexported functions",
+    "layer": 0,
+    "name": "helper"
+  },
+  {
+    "callees": [
+      "pong"
+    ],
+    "cycle": [
+      "ping",
+      "pong"
+    ],
+    "file": "PATH",
+    "group": "unexported functions",
+    "layer": 0,
+    "name": "ping"
+  },
+  {
+    "callees": [
+      "ping"
+    ],
+    "cycle": [
+      "ping",
+      "pong"
+    ],
+    "file": "PATH",
+    "group": "unexported functions",
+    "layer": 0,
+    "name": "pong"
+  },
+  {
+    "callees": [
+      "Start"
+    ],
+    "cycle": [
+      "Start",
+      "stop"
+    ],
+    "file": "PATH",
+    "group": "unexported functions",
+    "layer": 0,
+    "name": "stop"
+  },
+  {
+    "callees": [
+      "Server.loop"
+    ],
+    "file": "PATH",
+    "group": "exported methods of Server",
+    "layer": 0,
+    "name": "Server.Run"
+  },
+  {
+    "callees": [
+      "Server.step"
+    ],
+    "cycle": [
+      "Server.loop",
+      "Server.step"
+    ],
+    "file": "PATH",
+    "group": "unexported methods of Server",
+    "layer": 0,
+    "name": "Server.loop"
+  },
+  {
+    "callees": [
+      "Server.loop"
+    ],
+    "cycle": [
+      "Server.loop",
+      "Server.step"
+    ],
+    "file": "PATH",
+    "group": "unexported methods of Server",
+    "layer": 0,
+    "name": "Server.step"
+  }
+]
+`,
+			format: formatter.LayersFormatJSON,
+		},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		if err := formatter.WriteLayers(&out, layers, tt.format); err != nil {
+			t.Errorf("%s: write failed: %v", tt.format, err)
+		}
+		if expected := strings.ReplaceAll(tt.expected, "PATH", path); out.String() != expected {
+			t.Errorf("%s: unexpected layers, got:\n%s\nexpected:\n%s", tt.format, out.String(), expected)
+		}
+	}
+
+	var out strings.Builder
+	if err := formatter.WriteLayers(&out, layers, "svg"); err == nil {
+		t.Error("unknown format should fail")
+	}
+}
+
+func TestFormatterStable(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "main.go")
+	content := `package main
+
+func zeta() {}
+
+func run() { zeta() }
+
+var origin = Point{Z: 3, X: 1, y: 2}
+
+type Point struct {
+	Z int
+	y int
+	X int
+}
+
+func (p Point) b() {}
+
+func (p Point) a() {}
+
+func (p Point) Len() int { return 0 }
+
+const (
+	second = 2
+	First  = 1
+	third  = 3
+)
+`
+	expected := `package main
+
+const (
+	First = 1
+
+	second = 2
+	third  = 3
+)
+
+var origin = Point{Z: 3, X: 1, y: 2}
+
+type Point struct {
+	Z int
+	X int
+
+	y int
+}
+
+func (p Point) Len() int {
+	return 0
+}
+
+func (p Point) b() {}
+
+func (p Point) a() {}
+
+func zeta() {}
+
+func run() {
+	zeta()
+}
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := formatter.FormatFile(actualPath, formatter.Options{Stable: true, Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("stable mode should only move what violates a rule, got:\n%s", actualBytes)
+	}
+}
+
+func TestFormatterStableExplicitOrders(t *testing.T) {
+	content := `package main
+
+type Server struct{}
+
+type Config struct{}
+
+func alpha() {}
+
+func run() { zeta() }
+
+func zeta() {}
+`
+	tests := []struct {
+		expected string
+		name     string
+		opts     formatter.Options
+	}{
+		{
+			name: "step-down",
+			opts: formatter.Options{FuncSort: formatter.FuncSortStepDown},
+			expected: `package main
+
+type Server struct{}
+
+type Config struct{}
+
+func run() {
+	zeta()
+}
+
+func zeta() {}
+
+func alpha() {}
+`,
+		},
+		{
+			name: "layers",
+			opts: formatter.Options{FuncSort: formatter.FuncSortLayers},
+			expected: `package main
+
+type Server struct{}
+
+type Config struct{}
+
+func run() {
+	zeta()
+}
+
+func alpha() {}
+
+func zeta() {}
+`,
+		},
+		{
+			name: "type sort",
+			opts: formatter.Options{TypeSort: formatter.TypeSortAlphabetical},
+			expected: `package main
+
+type Config struct{}
+
+type Server struct{}
+
+func alpha() {}
+
+func run() {
+	zeta()
+}
+
+func zeta() {}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actualPath := filepath.Join(t.TempDir(), "main.go")
+			if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			tt.opts.Stable = true
+			tt.opts.Verify = true
+			if err := formatter.FormatFile(actualPath, tt.opts); err != nil {
+				t.Fatalf("formatter failed: %v", err)
+			}
+
+			actualBytes, err := os.ReadFile(actualPath)
+			if err != nil {
+				t.Fatalf("failed to read actual file: %v", err)
+			}
+
+			if string(actualBytes) != tt.expected {
+				t.Errorf("explicit orders should apply in stable mode, got:\n%s", actualBytes)
+			}
+		})
+	}
+}
+
+func TestFormatterStableTypeAware(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/typed\n\ngo 1.22\n",
+		"typed.go": `package typed
+
+import "image"
+
+type Pair[T any] struct {
+	Second T
+	First  T
+}
+
+var (
+	bounds = image.Rectangle{Max: image.Point{3, 4}, Min: image.Point{1, 2}}
+	ints   = Pair[int]{First: 2, Second: 1}
+)
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	if err := formatter.FormatDirectory(dir, formatter.Options{Stable: true, TypeAware: true, Verify: true}); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	expected := `package typed
+
+import "image"
+
+var (
+	bounds = image.Rectangle{Min: image.Point{X: 1, Y: 2}, Max: image.Point{X: 3, Y: 4}}
+	ints   = Pair[int]{Second: 1, First: 2}
+)
+
+type Pair[T any] struct {
+	Second T
+	First  T
+}
+`
+
+	actualBytes, err := os.ReadFile(filepath.Join(dir, "typed.go"))
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("formatted output does not match expected.\n\nActual:\n%s\n\nExpected:\n%s", actualBytes, expected)
+	}
+}
+
+func TestFormatterInterfaceMethods(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "store.go")
+	content := `package main
+
+type Store interface {
+	Put(key, value string)
+	Get(key string) string
+}
+
+type memStore struct{}
+
+func (s *memStore) String() string { return "" }
+
+func (s *memStore) Flush() {}
+
+func (s *memStore) Get(key string) string { return "" }
+
+func (s *memStore) Len() int { return 0 }
+
+func (s *memStore) Close() error { return nil }
+
+func (s *memStore) Put(key, value string) {}
+`
+	expected := `package main
+
+type Store interface {
+	Put(key, value string)
+	Get(key string) string
+}
+
+type memStore struct{}
+
+func (s *memStore) Put(key, value string) {}
+
+func (s *memStore) Get(key string) string {
+	return ""
+}
+
+func (s *memStore) Flush() {}
+
+func (s *memStore) Len() int {
+	return 0
+}
+
+func (s *memStore) String() string {
+	return ""
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	opts := formatter.Options{
+		InterfaceMethods: formatter.InterfaceMethods{Local: formatter.MethodPlacementFirst, Standard: formatter.MethodPlacementLast},
+		Verify:           true,
+	}
+	if err := formatter.FormatFile(actualPath, opts); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("methods should be grouped by interface, got:\n%s", actualBytes)
+	}
+}
+
+func TestFormatterInterfaceSort(t *testing.T) {
+	content := `package main
+
+import (
+	"fmt"
+	"io"
+)
+
+type Number interface {
+	String() string
+	~int | ~int64
+	fmt.Stringer
+}
+
+type Store interface {
+	// Put stores a value.
+	Put(key, value string)
+	reset()
+	Get(key string) string
+	io.Closer
+	fmt.Stringer
+
+	Stats() int
+	Debug() string
+}
+`
+	tests := []struct {
+		expected      string
+		interfaceSort string
+		name          string
+	}{
+		{
+			expected: `package main
+
+import (
+	"fmt"
+	"io"
+)
+
+type Number interface {
+	fmt.Stringer
+
+	~int | ~int64
+
+	String() string
+}
+
+type Store interface {
+	io.Closer
+	fmt.Stringer
+
+	// Put stores a value.
+	Put(key, value string)
+	reset()
+	Get(key string) string
+
+	Stats() int
+	Debug() string
+}
+`,
+			interfaceSort: formatter.InterfaceSortMembers,
+			name:          "members",
+		},
+		{
+			expected: `package main
+
+import (
+	"fmt"
+	"io"
+)
+
+type Number interface {
+	fmt.Stringer
+
+	~int | ~int64
+
+	String() string
+}
+
+type Store interface {
+	io.Closer
+	fmt.Stringer
+
+	Get(key string) string
+	// Put stores a value.
+	Put(key, value string)
+	reset()
+
+	Debug() string
+	Stats() int
+}
+`,
+			interfaceSort: formatter.InterfaceSortAlphabetical,
+			name:          "alphabetical",
+		},
+		{
+			expected:      content,
+			interfaceSort: formatter.InterfaceSortOriginal,
+			name:          "original",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actualPath := filepath.Join(t.TempDir(), "store.go")
+			if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			opts := formatter.Options{InterfaceSort: tt.interfaceSort, Verify: true}
+			if err := formatter.FormatFile(actualPath, opts); err != nil {
+				t.Fatalf("formatter failed: %v", err)
+			}
+
+			actualBytes, err := os.ReadFile(actualPath)
+			if err != nil {
+				t.Fatalf("failed to read actual file: %v", err)
+			}
+
+			if string(actualBytes) != tt.expected {
+				t.Errorf("interface members not ordered as expected, got:\n%s", actualBytes)
+			}
+		})
+	}
+}
+
+func TestFormatterTestLayout(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "parse_test.go")
+	content := `package parse
+
+import (
+	"os"
+	"testing"
+)
+
+type parseCase struct {
+	input string
+}
+
+func mustParse(t *testing.T, s string) string {
+	t.Helper()
+
+	return s
+}
+
+func ExampleParse() {}
+
+func FuzzParse(f *testing.F) {}
+
+func Example() {}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []parseCase{{input: "a"}} {
+		mustParse(t, tc.input)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {}
+
+func Testify() {}
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestFormat(t *testing.T) {}
+`
+	expected := `package parse
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+type parseCase struct {
+	input string
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []parseCase{{input: "a"}} {
+		mustParse(t, tc.input)
+	}
+}
+
+func TestFormat(t *testing.T) {}
+
+func BenchmarkParse(b *testing.B) {}
+
+func FuzzParse(f *testing.F) {}
+
+func Example() {}
+
+func ExampleParse() {}
+
+func mustParse(t *testing.T, s string) string {
+	t.Helper()
+
+	return s
+}
+
+func Testify() {}
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	opts := formatter.Options{TestLayout: formatter.TestLayoutOriginal, Verify: true}
+	if err := formatter.FormatFile(actualPath, opts); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	actualBytes, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatalf("failed to read actual file: %v", err)
+	}
+
+	if string(actualBytes) != expected {
+		t.Errorf("test file should follow the test layout, got:\n%s", actualBytes)
+	}
+}
+
+func TestFormatterTestLayoutDependencyIdempotent(t *testing.T) {
+	actualPath := filepath.Join(t.TempDir(), "x_test.go")
+	content := `package x
+
+import "testing"
+
+type C struct{}
+
+type B struct{ c C }
+
+type A struct{}
+
+func TestX(t *testing.T) { _ = B{} }
+`
+	expected := `package x
+
+import "testing"
+
+type C struct{}
+
+type A struct{}
+
+type B struct {
+	c C
+}
+
+func TestX(t *testing.T) {
+	_ = B{}
+}
+`
+
+	if err := os.WriteFile(actualPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	opts := formatter.Options{TestLayout: formatter.TestLayoutOriginal, TypeSort: formatter.TypeSortDependency, Verify: true}
+	for range 2 {
+		if err := formatter.FormatFile(actualPath, opts); err != nil {
+			t.Fatalf("formatter failed: %v", err)
+		}
+
+		actualBytes, err := os.ReadFile(actualPath)
+		if err != nil {
+			t.Fatalf("failed to read actual file: %v", err)
+		}
+
+		if string(actualBytes) != expected {
+			t.Fatalf("types not used by a function should stay sorted, got:\n%s", actualBytes)
+		}
+	}
+}
+
+func TestFormatterOrphanMethods(t *testing.T) {
+	files := map[string]string{
+		"types.go": `package app
+
+type Client struct{}
+
+type Server struct{}
+`,
+		"ext.go": `package app
+
+func (s *Server) stop() {}
+
+func (c *Client) Do() {}
+
+func (s *Server) Start() {}
+
+func (c *Client) close() {}
+`,
+	}
+	tests := []struct {
+		expected string
+		name     string
+		opts     formatter.Options
+	}{
+		{
+			expected: `package app
+
+func (s *Server) Start() {}
+
+func (s *Server) stop() {}
+
+func (c *Client) Do() {}
+
+func (c *Client) close() {}
+`,
+			name: "file",
+		},
+		{
+			expected: `package app
+
+func (c *Client) Do() {}
+
+func (c *Client) close() {}
+
+func (s *Server) Start() {}
+
+func (s *Server) stop() {}
+`,
+			name: "package",
+			opts: formatter.Options{PackageMode: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+
+			tt.opts.Verify = true
+			if err := formatter.FormatFile(filepath.Join(dir, "ext.go"), tt.opts); err != nil {
+				t.Fatalf("formatter failed: %v", err)
+			}
+
+			got, err := os.ReadFile(filepath.Join(dir, "ext.go"))
+			if err != nil {
+				t.Fatalf("failed to read ext.go: %v", err)
+			}
+			if string(got) != tt.expected {
+				t.Errorf("orphan methods should be grouped by receiver type, got:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestFormatterMoveOrphanMethods(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"server.go": `package app
+
+type Server struct {
+	name string
+}
+`,
+		"ext.go": `package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Describe returns a description of the server.
+func (s *Server) Describe() string {
+	return fmt.Sprintf("server %s", strings.ToUpper(s.name))
+}
+
+func greet(name string) string {
+	return fmt.Sprint("hello ", name)
+}
+`,
+		"ext_linux.go": `package app
+
+func (s *Server) linux() {}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	opts := formatter.Options{MoveOrphanMethods: true, PackageMode: true, Verify: true}
+	if err := formatter.FormatDirectory(dir, opts); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"server.go": `package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Server struct {
+	name string
+}
+
+// Describe returns a description of the server.
+func (s *Server) Describe() string {
+	return fmt.Sprintf("server %s", strings.ToUpper(s.name))
+}
+`,
+		"ext.go": `package app
+
+import "fmt"
+
+func greet(name string) string {
+	return fmt.Sprint("hello ", name)
+}
+`,
+		"ext_linux.go": files["ext_linux.go"],
+	}
+	for name, want := range expected {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s does not match expected.\n\nActual:\n%s\n\nExpected:\n%s", name, got, want)
+		}
+	}
+
+	if err := formatter.FormatDirectory(t.TempDir(), formatter.Options{MoveOrphanMethods: true}); err == nil {
+		t.Error("moving orphan methods without package mode should fail")
+	}
+}
+
+func TestFormatterMoveOrphanMethodsImportNames(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.22\n",
+		"core/v1/core.go": `package v1
+
+func Name() string { return "core" }
+`,
+		"app/server.go": `package app
+
+type Server struct{}
+`,
+		"app/core.go": `package app
+
+import "example.com/m/core/v1"
+
+func (s *Server) Name() string {
+	return v1.Name()
+}
+`,
+		"app/missing.go": `package app
+
+import "example.com/missing/lib"
+
+func (s *Server) Missing() string {
+	return lib.Name()
+}
+`,
+	}
+	for name, content := range files {
+		filePath := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			t.Fatalf("failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	opts := formatter.Options{MoveOrphanMethods: true, PackageMode: true, Verify: true}
+	if err := formatter.FormatDirectory(filepath.Join(dir, "app"), opts); err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"app/server.go": `package app
+
+import "example.com/m/core/v1"
+
+type Server struct{}
+
+func (s *Server) Name() string {
+	return v1.Name()
+}
+`,
+		"app/core.go":    "package app\n",
+		"app/missing.go": files["app/missing.go"],
+	}
+	for name, want := range expected {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s does not match expected.\n\nActual:\n%s\n\nExpected:\n%s", name, got, want)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package formatter
 
 import (
+	"go/token"
+	"go/types"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,6 +21,13 @@ func getSpecExportGroup(vs *dst.ValueSpec) int {
 	return getExportGroup(vs.Names[0].Name)
 }
 
+// isConstraintInterface reports whether the interface has type-set terms
+// (~int, int | string, comparable), which makes it usable only as a type
+// constraint.
+func isConstraintInterface(iface *dst.InterfaceType) bool {
+	return iface.Methods != nil && lo.ContainsBy(iface.Methods.List, isTypeSetTerm)
+}
+
 func detectGoVersion(filePath string) string {
 	modPath := findGoMod(filePath)
 	if modPath == "" {
@@ -38,24 +47,6 @@ func detectGoVersion(filePath string) string {
 	return "go" + mf.Go.Version
 }
 
-func findConstructorType(fn *dst.FuncDecl, typeNames map[string]bool) string {
-	if fn.Type.Results == nil || len(fn.Type.Results.List) == 0 {
-		return ""
-	}
-
-	for _, result := range fn.Type.Results.List {
-		typeName := extractTypeName(result.Type)
-		if typeName == "" || !typeNames[typeName] {
-			continue
-		}
-		if matchesConstructorPattern(fn.Name.Name, typeName) {
-			return typeName
-		}
-	}
-
-	return ""
-}
-
 func getExportGroup(name string) int {
 	switch {
 	case name == "_":
@@ -99,6 +90,49 @@ func isFuncInterface(iface *dst.InterfaceType) bool {
 	return iface.Methods != nil && len(iface.Methods.List) == 1 && isFuncType(iface.Methods.List[0].Type)
 }
 
+// isTypeSetTerm reports whether an interface element is a type-set term
+// (~int, int | string, a non-interface predeclared type) rather than a method
+// or an embedded interface.
+func isTypeSetTerm(field *dst.Field) bool {
+	if len(field.Names) > 0 {
+		return false
+	}
+
+	switch t := field.Type.(type) {
+	case *dst.UnaryExpr, *dst.BinaryExpr:
+		return true
+	case *dst.Ident:
+		return t.Path == "" && isPredeclaredNonInterface(t.Name)
+	}
+
+	return false
+}
+
+// optionTargetName returns S for a functional option type func(*S), and an
+// empty string for other types.
+func optionTargetName(ts *dst.TypeSpec) string {
+	ft, ok := ts.Type.(*dst.FuncType)
+	if !ok || ft.Params == nil || len(ft.Params.List) != 1 || len(ft.Params.List[0].Names) > 1 {
+		return ""
+	}
+	if ft.Results != nil && len(ft.Results.List) > 0 {
+		if len(ft.Results.List) != 1 || !isErrorType(ft.Results.List[0].Type) {
+			return ""
+		}
+	}
+
+	star, ok := ft.Params.List[0].Type.(*dst.StarExpr)
+	if !ok {
+		return ""
+	}
+	ident, ok := star.X.(*dst.Ident)
+	if !ok || ident.Path != "" {
+		return ""
+	}
+
+	return ident.Name
+}
+
 func containsIota(expr dst.Expr) bool {
 	switch e := expr.(type) {
 	case *dst.Ident:
@@ -120,6 +154,47 @@ func containsIota(expr dst.Expr) bool {
 	return false
 }
 
+// declaredNames returns the names declared anywhere within node: parameters,
+// results, fields, variables, constants, types, := and range variables. It
+// ignores scopes, so a name declared in one block hides it in the others too.
+func declaredNames(node dst.Node) map[string]bool {
+	names := make(map[string]bool)
+	addIdents := func(exprs ...dst.Expr) {
+		for _, expr := range exprs {
+			if ident, ok := expr.(*dst.Ident); ok {
+				names[ident.Name] = true
+			}
+		}
+	}
+
+	dst.Inspect(node, func(n dst.Node) bool {
+		switch node := n.(type) {
+		case *dst.Field:
+			for _, name := range node.Names {
+				names[name.Name] = true
+			}
+		case *dst.ValueSpec:
+			for _, name := range node.Names {
+				names[name.Name] = true
+			}
+		case *dst.TypeSpec:
+			names[node.Name.Name] = true
+		case *dst.AssignStmt:
+			if node.Tok == token.DEFINE {
+				addIdents(node.Lhs...)
+			}
+		case *dst.RangeStmt:
+			if node.Tok == token.DEFINE {
+				addIdents(node.Key, node.Value)
+			}
+		}
+
+		return true
+	})
+
+	return names
+}
+
 func extractTypeName(expr dst.Expr) string {
 	switch t := expr.(type) {
 	case *dst.Ident:
@@ -154,6 +229,59 @@ func findGoMod(filePath string) string {
 	return ""
 }
 
+// getConstTypeName returns the type of a constant spec: its declared type, or
+// the conversion its value is wrapped in (A = Kind(iota)).
+func getConstTypeName(spec dst.Spec) string {
+	vs, ok := spec.(*dst.ValueSpec)
+	if !ok {
+		return ""
+	}
+	if vs.Type != nil {
+		if ident, ok := vs.Type.(*dst.Ident); ok {
+			return ident.Name
+		}
+
+		return ""
+	}
+	if len(vs.Values) == 0 {
+		return ""
+	}
+	if call, ok := vs.Values[0].(*dst.CallExpr); ok && len(call.Args) == 1 {
+		if ident, ok := call.Fun.(*dst.Ident); ok {
+			return ident.Name
+		}
+	}
+
+	return ""
+}
+
+// getReceiverName returns the name of the receiver variable, or an empty
+// string if it is unnamed or blank.
+func getReceiverName(fn *dst.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 || len(fn.Recv.List[0].Names) == 0 {
+		return ""
+	}
+	if name := fn.Recv.List[0].Names[0].Name; name != "_" {
+		return name
+	}
+
+	return ""
+}
+
+// getSingleResultTypeName returns the type name of the only result of the
+// function, or an empty string if it has none or several.
+func getSingleResultTypeName(fn *dst.FuncDecl) string {
+	results := fn.Type.Results
+	if results == nil || len(results.List) != 1 || len(results.List[0].Names) > 1 {
+		return ""
+	}
+	if ident, ok := results.List[0].Type.(*dst.Ident); ok {
+		return ident.Name
+	}
+
+	return ""
+}
+
 func getSpecFirstName(spec dst.Spec) string {
 	switch s := spec.(type) {
 	case *dst.ValueSpec:
@@ -178,6 +306,12 @@ func isBlankVarSpec(spec dst.Spec) bool {
 	})
 }
 
+func isErrorType(expr dst.Expr) bool {
+	ident, ok := expr.(*dst.Ident)
+
+	return ok && ident.Name == "error" && ident.Path == ""
+}
+
 func isExported(name string) bool {
 	return len(name) > 0 && unicode.IsUpper(rune(name[0]))
 }
@@ -202,24 +336,39 @@ func isGeneratedFile(f *dst.File) bool {
 		strings.HasPrefix(firstComment, "// Automatically generated")
 }
 
-func matchesConstructorPattern(funcName, typeName string) bool {
-	var suffix string
-	if strings.HasPrefix(funcName, "New") {
-		suffix = strings.TrimPrefix(funcName, "New")
-	} else if strings.HasPrefix(funcName, "new") {
-		suffix = strings.TrimPrefix(funcName, "new")
-	} else {
+// isPredeclaredNonInterface reports whether name is a predeclared type that
+// cannot be embedded in an ordinary interface (int, string, comparable, ...).
+func isPredeclaredNonInterface(name string) bool {
+	obj, ok := types.Universe.Lookup(name).(*types.TypeName)
+	if !ok {
 		return false
 	}
 
-	if strings.EqualFold(suffix, typeName) {
-		return true
-	}
-	if len(suffix) > len(typeName) && strings.EqualFold(suffix[:len(typeName)], typeName) {
-		nextChar := rune(suffix[len(typeName)])
+	return name == "comparable" || !types.IsInterface(obj.Type())
+}
 
-		return !unicode.IsLower(nextChar)
+func isTestFile(filePath string) bool {
+	return strings.HasSuffix(filePath, "_test.go")
+}
+
+// literalTypeName returns the name of a composite literal type. Unlike
+// extractTypeName it keeps the package qualifier, so "pkg.T" never matches a
+// local "T".
+func literalTypeName(expr dst.Expr) string {
+	switch t := expr.(type) {
+	case *dst.Ident:
+		return t.Name
+	case *dst.StarExpr:
+		return literalTypeName(t.X)
+	case *dst.SelectorExpr:
+		if x, ok := t.X.(*dst.Ident); ok {
+			return x.Name + "." + t.Sel.Name
+		}
+	case *dst.IndexExpr:
+		return literalTypeName(t.X)
+	case *dst.IndexListExpr:
+		return literalTypeName(t.X)
 	}
 
-	return false
+	return ""
 }
@@ -0,0 +1,48 @@
+package formatter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/hexops/gotextdiff"
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+)
+
+var ErrNotIdempotent = errors.New("formatting is not idempotent")
+
+// verifyIdempotent formats the already formatted output once more, in memory,
+// and reports a diff if the second pass changes it.
+func verifyIdempotent(sf *sourceFile, formatted []byte, opts Options) error {
+	f, err := parseSource(sf.path, formatted)
+	if err != nil {
+		return fmt.Errorf("%s: parse formatted output: %w", sf.path, err)
+	}
+
+	opts.Verify = false
+	opts.VerifyIdempotent = false
+
+	second, err := formatParsedFile(&sourceFile{
+		file:     f,
+		path:     sf.path,
+		pkg:      sf.pkg,
+		src:      formatted,
+		writable: true,
+	}, opts)
+	if err != nil {
+		return fmt.Errorf("%s: second pass: %w", sf.path, err)
+	}
+
+	if bytes.Equal(formatted, second) {
+		return nil
+	}
+
+	return fmt.Errorf("%s: %w:\n%s", sf.path, ErrNotIdempotent, unifiedDiff(sf.path, formatted, second))
+}
+
+func unifiedDiff(filePath string, before, after []byte) string {
+	edits := myers.ComputeEdits(span.URIFromPath(filePath), string(before), string(after))
+
+	return fmt.Sprint(gotextdiff.ToUnified(filePath+" (first pass)", filePath+" (second pass)", string(before), edits))
+}
@@ -0,0 +1,61 @@
+package formatter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerifyIdempotent(t *testing.T) {
+	const filePath = "main.go"
+	unformatted := []byte("package main\n\nfunc main() {}\nvar x = 1\n")
+	formatted, err := formatSource(filePath, unformatted, Options{})
+	if err != nil {
+		t.Fatalf("formatter failed: %v", err)
+	}
+
+	tests := []struct {
+		// first stands for the output of the first pass.
+		first      []byte
+		idempotent bool
+		name       string
+	}{
+		{
+			first:      formatted,
+			idempotent: true,
+			name:       "unchanged",
+		},
+		{
+			first: unformatted,
+			name:  "changed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseSource(filePath, tt.first)
+			if err != nil {
+				t.Fatalf("failed to parse file: %v", err)
+			}
+
+			err = verifyIdempotent(&sourceFile{file: f, path: filePath, src: tt.first, writable: true}, tt.first, Options{})
+			if tt.idempotent {
+				if err != nil {
+					t.Errorf("idempotency check failed: %v", err)
+				}
+
+				return
+			}
+
+			if !errors.Is(err, ErrNotIdempotent) {
+				t.Fatalf("idempotency check should fail with ErrNotIdempotent, got: %v", err)
+			}
+			if diff := unifiedDiff(filePath, tt.first, formatted); !strings.Contains(err.Error(), diff) {
+				t.Errorf("error should carry the diff of both passes:\n%s\ngot: %v", diff, err)
+			}
+			if !strings.Contains(err.Error(), "--- main.go (first pass)\n+++ main.go (second pass)\n@@ ") {
+				t.Errorf("diff should compare both passes, got: %v", err)
+			}
+		})
+	}
+}
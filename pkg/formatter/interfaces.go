@@ -0,0 +1,287 @@
+package formatter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dave/dst"
+)
+
+const (
+	// Placements of interface method groups. See InterfaceMethods.
+	MethodPlacementFirst = "first"
+	MethodPlacementLast  = "last"
+)
+
+// standardInterfaces are the well-known standard library interfaces methods are
+// grouped by, in order. A method belongs to the first interface it completes,
+// so more specific interfaces come first.
+var standardInterfaces = []standardInterface{
+	{methods: []methodShape{{name: "Error", params: 0, results: 1}}, name: "error"},
+	{methods: []methodShape{{name: "String", params: 0, results: 1}}, name: "fmt.Stringer"},
+	{methods: []methodShape{{name: "GoString", params: 0, results: 1}}, name: "fmt.GoStringer"},
+	{methods: []methodShape{{name: "Format", params: 2, results: 0}}, name: "fmt.Formatter"},
+	{methods: []methodShape{{name: "Len", params: 0, results: 1}, {name: "Less", params: 2, results: 1}, {name: "Swap", params: 2, results: 0}, {name: "Push", params: 1, results: 0}, {name: "Pop", params: 0, results: 1}}, name: "heap.Interface"},
+	{methods: []methodShape{{name: "Len", params: 0, results: 1}, {name: "Less", params: 2, results: 1}, {name: "Swap", params: 2, results: 0}}, name: "sort.Interface"},
+	{methods: []methodShape{{name: "Read", params: 1, results: 2}}, name: "io.Reader"},
+	{methods: []methodShape{{name: "ReadAt", params: 2, results: 2}}, name: "io.ReaderAt"},
+	{methods: []methodShape{{name: "ReadFrom", params: 1, results: 2}}, name: "io.ReaderFrom"},
+	{methods: []methodShape{{name: "Write", params: 1, results: 2}}, name: "io.Writer"},
+	{methods: []methodShape{{name: "WriteTo", params: 1, results: 2}}, name: "io.WriterTo"},
+	{methods: []methodShape{{name: "Seek", params: 2, results: 2}}, name: "io.Seeker"},
+	{methods: []methodShape{{name: "Close", params: 0, results: 1}}, name: "io.Closer"},
+	{methods: []methodShape{{name: "ServeHTTP", params: 2, results: 0}}, name: "http.Handler"},
+	{methods: []methodShape{{name: "MarshalJSON", params: 0, results: 2}}, name: "json.Marshaler"},
+	{methods: []methodShape{{name: "UnmarshalJSON", params: 1, results: 1}}, name: "json.Unmarshaler"},
+	{methods: []methodShape{{name: "MarshalText", params: 0, results: 2}}, name: "encoding.TextMarshaler"},
+	{methods: []methodShape{{name: "UnmarshalText", params: 1, results: 1}}, name: "encoding.TextUnmarshaler"},
+	{methods: []methodShape{{name: "MarshalBinary", params: 0, results: 2}}, name: "encoding.BinaryMarshaler"},
+	{methods: []methodShape{{name: "UnmarshalBinary", params: 1, results: 1}}, name: "encoding.BinaryUnmarshaler"},
+	{methods: []methodShape{{name: "Value", params: 0, results: 2}}, name: "driver.Valuer"},
+	{methods: []methodShape{{name: "Scan", params: 1, results: 1}}, name: "sql.Scanner"},
+}
+
+// InterfaceMethods groups the methods of a type by the interfaces it
+// implements. Every group lists the methods in the order the interface declares
+// them. An empty placement leaves these methods sorted with the others.
+type InterfaceMethods struct {
+	// Local is the placement of methods implementing interfaces declared in the
+	// file, in declaration order of the interfaces.
+	Local string `yaml:"local"`
+	// Standard is the placement of methods implementing well-known standard
+	// library interfaces (fmt.Stringer, error, io.Reader, json.Marshaler...).
+	// Placed last, they come after the local interface groups.
+	Standard string `yaml:"standard"`
+}
+
+func (m InterfaceMethods) validate() error {
+	for _, placement := range []struct {
+		name, value string
+	}{{name: "local", value: m.Local}, {name: "standard", value: m.Standard}} {
+		switch placement.value {
+		case "", MethodPlacementFirst, MethodPlacementLast:
+		default:
+			return fmt.Errorf("interface methods: %s: unknown placement %q (known: %s, %s)", placement.name, placement.value, MethodPlacementFirst, MethodPlacementLast)
+		}
+	}
+
+	return nil
+}
+
+// methodGrouper moves the methods implementing interfaces into groups placed
+// before or after the other methods of a type.
+type methodGrouper struct {
+	// local holds the method names of the interfaces declared in the file, in
+	// declaration order.
+	local     [][]string
+	placement InterfaceMethods
+}
+
+func newMethodGrouper(placement InterfaceMethods) *methodGrouper {
+	return &methodGrouper{placement: placement}
+}
+
+func (g *methodGrouper) collectInterfaces(f *dst.File) {
+	if g.placement.Local == "" {
+		return
+	}
+
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*dst.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts, ok := spec.(*dst.TypeSpec)
+			if !ok {
+				continue
+			}
+			if iface, ok := ts.Type.(*dst.InterfaceType); ok {
+				if names, ok := interfaceMethodNames(iface); ok {
+					g.local = append(g.local, names)
+				}
+			}
+		}
+	}
+}
+
+// group reorders the sorted methods of a type: the groups placed first, the
+// other methods in their order, then the groups placed last.
+func (g *methodGrouper) group(methods []*dst.FuncDecl) []*dst.FuncDecl {
+	if g.placement.Local == "" && g.placement.Standard == "" {
+		return methods
+	}
+
+	byName := make(map[string]*dst.FuncDecl)
+	names := make(map[string]bool)
+	for _, fn := range methods {
+		byName[fn.Name.Name] = fn
+		names[fn.Name.Name] = true
+	}
+	grouped := make(map[*dst.FuncDecl]bool)
+	take := func(want []string) []*dst.FuncDecl {
+		var result []*dst.FuncDecl
+		for _, name := range want {
+			if fn := byName[name]; fn != nil && !grouped[fn] {
+				grouped[fn] = true
+				result = append(result, fn)
+			}
+		}
+
+		return result
+	}
+
+	var local, standard []*dst.FuncDecl
+	if g.placement.Local != "" {
+		for _, ifaceNames := range g.local {
+			if implementsAll(names, ifaceNames) {
+				local = append(local, take(ifaceNames)...)
+			}
+		}
+	}
+	if g.placement.Standard != "" {
+		for _, iface := range standardInterfaces {
+			if iface.implementedBy(byName) {
+				standard = append(standard, take(iface.methodNames())...)
+			}
+		}
+	}
+
+	var first, last []*dst.FuncDecl
+	for _, part := range []struct {
+		methods   []*dst.FuncDecl
+		placement string
+	}{{methods: local, placement: g.placement.Local}, {methods: standard, placement: g.placement.Standard}} {
+		if part.placement == MethodPlacementFirst {
+			first = append(first, part.methods...)
+		} else {
+			last = append(last, part.methods...)
+		}
+	}
+
+	result := make([]*dst.FuncDecl, 0, len(methods))
+	result = append(result, first...)
+	for _, fn := range methods {
+		if !grouped[fn] {
+			result = append(result, fn)
+		}
+	}
+
+	return append(result, last...)
+}
+
+// methodShape is a method name with its parameter and result counts.
+type methodShape struct {
+	name    string
+	params  int
+	results int
+}
+
+func (s methodShape) matches(fn *dst.FuncDecl) bool {
+	return fn.Name.Name == s.name && countFields(fn.Type.Params) == s.params && countFields(fn.Type.Results) == s.results
+}
+
+type standardInterface struct {
+	methods []methodShape
+	name    string
+}
+
+func (i standardInterface) implementedBy(methods map[string]*dst.FuncDecl) bool {
+	for _, shape := range i.methods {
+		if fn := methods[shape.name]; fn == nil || !shape.matches(fn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (i standardInterface) methodNames() []string {
+	names := make([]string, 0, len(i.methods))
+	for _, shape := range i.methods {
+		names = append(names, shape.name)
+	}
+
+	return names
+}
+
+// reorderInterfaceMembers orders the members of every interface type of the
+// file: embedded interfaces first, sorted by name, then type-set terms in their
+// order, then methods. Blank lines separate the three groups. Methods keep
+// their blank-line groups, sorted within each group with
+// InterfaceSortAlphabetical. Interfaces are left alone unless interfaceSort is
+// set, so stable mode is not consulted.
+func reorderInterfaceMembers(f *dst.File, interfaceSort string) {
+	if interfaceSort == "" || interfaceSort == InterfaceSortOriginal {
+		return
+	}
+
+	dst.Inspect(f, func(n dst.Node) bool {
+		if iface, ok := n.(*dst.InterfaceType); ok && iface.Methods != nil && len(iface.Methods.List) > 0 {
+			iface.Methods.List = reorderInterfaceElements(iface.Methods.List, interfaceSort == InterfaceSortAlphabetical)
+		}
+
+		return true
+	})
+}
+
+// countFields returns the number of parameters or results in a field list.
+func countFields(fields *dst.FieldList) int {
+	if fields == nil {
+		return 0
+	}
+
+	var n int
+	for _, field := range fields.List {
+		n += max(len(field.Names), 1)
+	}
+
+	return n
+}
+
+func reorderInterfaceElements(elements []*dst.Field, sortMethods bool) []*dst.Field {
+	var embedded, terms []*dst.Field
+	var methodGroups [][]*dst.Field
+	for _, field := range elements {
+		switch {
+		case len(field.Names) > 0:
+			if len(methodGroups) == 0 || field.Decs.Before == dst.EmptyLine {
+				methodGroups = append(methodGroups, nil)
+			}
+			methodGroups[len(methodGroups)-1] = append(methodGroups[len(methodGroups)-1], field)
+		case isTypeSetTerm(field):
+			terms = append(terms, field)
+		default:
+			embedded = append(embedded, field)
+		}
+	}
+
+	sortFieldsByTypeName(embedded)
+	if sortMethods {
+		for _, group := range methodGroups {
+			sort.SliceStable(group, func(i, j int) bool {
+				nameI, nameJ := group[i].Names[0].Name, group[j].Names[0].Name
+				if isExported(nameI) != isExported(nameJ) {
+					return isExported(nameI)
+				}
+
+				return nameI < nameJ
+			})
+		}
+	}
+
+	result := make([]*dst.Field, 0, len(elements))
+	for _, group := range append([][]*dst.Field{embedded, terms}, methodGroups...) {
+		for i, field := range group {
+			switch {
+			case i == 0 && len(result) > 0:
+				field.Decs.Before = dst.EmptyLine
+			case i == 0 || sortMethods || field.Decs.Before != dst.EmptyLine:
+				field.Decs.Before = dst.NewLine
+			}
+			result = append(result, field)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,373 @@
+package formatter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dave/dst"
+	"github.com/samber/lo"
+)
+
+const (
+	// Output formats of WriteLayers.
+	LayersFormatDOT     = "dot"
+	LayersFormatJSON    = "json"
+	LayersFormatMermaid = "mermaid"
+	LayersFormatText    = "text"
+)
+
+// FuncLayer is a function or method with its place in the call graph it is
+// ordered by.
+type FuncLayer struct {
+	// Callees are the listed functions the function references, in order of
+	// first reference, including those of other groups.
+	Callees []string `json:"callees"`
+	// Cycle lists the listed functions that reference each other in a cycle
+	// with this one, including itself. Within a group, they share the same
+	// layer.
+	Cycle []string `json:"cycle,omitempty"`
+	File  string   `json:"file"`
+	// Group is the set of functions layered together, e.g. "exported functions"
+	// or "unexported methods of Server".
+	Group string `json:"group"`
+	Layer int    `json:"layer"`
+	// Name is the function name, qualified with the receiver type for methods
+	// (Server.Run).
+	Name string `json:"name"`
+}
+
+// callInfo holds the callees and cycles of a set of functions, by funcKey.
+type callInfo struct {
+	callees map[string][]string
+	cycles  map[string][]string
+}
+
+// newCallInfo builds the call graph of funcs. Callees are in order of first
+// reference and cycles are sorted.
+func newCallInfo(funcs []*dst.FuncDecl, callsOnly bool) callInfo {
+	funcNames := lo.SliceToMap(funcs, func(fn *dst.FuncDecl) (string, bool) {
+		return funcKey(fn), true
+	})
+	callGraph := buildCallGraph(funcs, funcNames, callsOnly)
+	sccs, sccID := findSCCs(callGraph, funcNames)
+
+	info := callInfo{callees: make(map[string][]string), cycles: make(map[string][]string)}
+	for key := range funcNames {
+		info.callees[key] = lo.Uniq(callGraph[key])
+		if scc := sccs[sccID[key]]; len(scc) > 1 {
+			info.cycles[key] = append([]string(nil), scc...)
+			sort.Strings(info.cycles[key])
+		}
+	}
+
+	return info
+}
+
+// InspectLayers returns the architectural layers of the functions and methods
+// at path, as the formatter computes them. For a file, every group of
+// functions sorted together is layered on its own: exported and unexported
+// standalone functions, methods of every type and orphan methods of every
+// receiver type. Callees and cycles are still those of the whole file. For a
+// directory, or a file with Options.PackageLayers, the call graph of the whole
+// package is used. Constructors and functional options are sorted by name and
+// are not listed.
+func InspectLayers(path string, opts Options) ([]FuncLayer, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return inspectPackageLayers(path, "", opts)
+	}
+	if opts.PackageLayers && (opts.PackageLayersTests || !isTestFile(path)) {
+		return inspectPackageLayers(filepath.Dir(path), path, opts)
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseSource(path, src)
+	if err != nil {
+		return nil, err
+	}
+
+	return inspectFileLayers(f, path, opts), nil
+}
+
+// WriteLayers writes the layers in the given format (see the LayersFormat
+// constants).
+func WriteLayers(w io.Writer, layers []FuncLayer, format string) error {
+	switch format {
+	case "", LayersFormatText:
+		return writeLayersText(w, layers)
+	case LayersFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(layers)
+	case LayersFormatDOT:
+		return writeLayersDOT(w, layers)
+	case LayersFormatMermaid:
+		return writeLayersMermaid(w, layers)
+	}
+
+	return fmt.Errorf("layers format: unknown value %q (known: %s, %s, %s, %s)", format, LayersFormatText, LayersFormatJSON, LayersFormatDOT, LayersFormatMermaid)
+}
+
+func writeLayersDOT(w io.Writer, layers []FuncLayer) error {
+	var b strings.Builder
+	b.WriteString("digraph layers {\n")
+	groups := groupLayers(layers)
+	inner, cross := layerEdges(groups)
+	for i, group := range groups {
+		fmt.Fprintf(&b, "\tsubgraph cluster_%d {\n\t\tlabel=%s;\n", i, strconv.Quote(group[0].Group))
+		for _, l := range group {
+			fmt.Fprintf(&b, "\t\t%s [label=%s];\n", layerNodeID(i, l.Name), strconv.Quote(fmt.Sprintf("%s\nlayer %d", l.Name, l.Layer)))
+		}
+		for _, edge := range inner[i] {
+			fmt.Fprintf(&b, "\t\t%s -> %s;\n", edge[0], edge[1])
+		}
+		b.WriteString("\t}\n")
+	}
+	for _, edge := range cross {
+		fmt.Fprintf(&b, "\t%s -> %s;\n", edge[0], edge[1])
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+func writeLayersMermaid(w io.Writer, layers []FuncLayer) error {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	groups := groupLayers(layers)
+	inner, cross := layerEdges(groups)
+	for i, group := range groups {
+		fmt.Fprintf(&b, "\tsubgraph g%d [%s]\n", i, strconv.Quote(group[0].Group))
+		for _, l := range group {
+			fmt.Fprintf(&b, "\t\t%s[\"%s (layer %d)\"]\n", layerNodeID(i, l.Name), l.Name, l.Layer)
+		}
+		for _, edge := range inner[i] {
+			fmt.Fprintf(&b, "\t\t%s --> %s\n", edge[0], edge[1])
+		}
+		b.WriteString("\tend\n")
+	}
+	for _, edge := range cross {
+		fmt.Fprintf(&b, "\t%s --> %s\n", edge[0], edge[1])
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+func inspectFileLayers(f *dst.File, filePath string, opts Options) []FuncLayer {
+	c := newDeclCollector(filePath, nil, nil, opts)
+	c.collect(f)
+
+	type group struct {
+		funcs []*dst.FuncDecl
+		what  string
+	}
+	groups := []group{{
+		funcs: lo.FilterMap(c.functions, func(d dst.Decl, _ int) (*dst.FuncDecl, bool) {
+			fn, ok := d.(*dst.FuncDecl)
+
+			return fn, ok
+		}),
+		what: "functions",
+	}}
+	typeNames := lo.Keys(c.methodsByType)
+	sort.Strings(typeNames)
+	for _, typeName := range typeNames {
+		groups = append(groups, group{funcs: c.methodsByType[typeName], what: "methods of " + typeName})
+	}
+	orphansByType := lo.GroupBy(c.orphanMethods, func(fn *dst.FuncDecl) string {
+		return getReceiverTypeName(fn)
+	})
+	typeNames = lo.Keys(orphansByType)
+	sort.Strings(typeNames)
+	for _, typeName := range typeNames {
+		groups = append(groups, group{funcs: orphansByType[typeName], what: "orphan methods of " + typeName})
+	}
+
+	// Every group is layered on its own, but calls cross groups.
+	calls := newCallInfo(lo.FlatMap(groups, func(g group, _ int) []*dst.FuncDecl { return g.funcs }), opts.LayerCallsOnly)
+	fileOf := func(*dst.FuncDecl) string { return filePath }
+
+	var result []FuncLayer
+	for _, g := range groups {
+		exported, unexported := lo.FilterReject(g.funcs, func(fn *dst.FuncDecl, _ int) bool {
+			return isExported(fn.Name.Name)
+		})
+		result = append(result, describeLayers("exported "+g.what, exported, calls, fileOf, opts.LayerCallsOnly)...)
+		result = append(result, describeLayers("unexported "+g.what, unexported, calls, fileOf, opts.LayerCallsOnly)...)
+	}
+
+	return result
+}
+
+func inspectPackageLayers(dir, only string, opts Options) ([]FuncLayer, error) {
+	files, _, err := loadPackageFiles(dir, "", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	fileOf := make(map[*dst.FuncDecl]string)
+	funcsByPackage := make(map[string][]*dst.FuncDecl)
+	for _, pf := range files {
+		if !opts.PackageLayersTests && isTestFile(pf.path) {
+			continue
+		}
+		for _, decl := range pf.file.Decls {
+			if fn, ok := decl.(*dst.FuncDecl); ok {
+				fileOf[fn] = pf.path
+				funcsByPackage[pf.file.Name.Name] = append(funcsByPackage[pf.file.Name.Name], fn)
+			}
+		}
+	}
+
+	pkgNames := lo.Keys(funcsByPackage)
+	sort.Strings(pkgNames)
+
+	var result []FuncLayer
+	for _, pkgName := range pkgNames {
+		funcs := funcsByPackage[pkgName]
+		layers := describeLayers("package "+pkgName, funcs, newCallInfo(funcs, opts.LayerCallsOnly), func(fn *dst.FuncDecl) string {
+			return fileOf[fn]
+		}, opts.LayerCallsOnly)
+		for _, l := range layers {
+			if only == "" || filepath.Clean(l.File) == filepath.Clean(only) {
+				result = append(result, l)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// layerEdges returns the calls of groups as edges between node identifiers:
+// the edges within every group, and the edges to other groups. The latter are
+// written outside of any group, so that a node stays in the group declaring
+// it.
+func layerEdges(groups [][]FuncLayer) ([][][2]string, [][2]string) {
+	groupOf := make(map[string]int)
+	for i, group := range groups {
+		for _, l := range group {
+			if _, ok := groupOf[l.Name]; !ok {
+				groupOf[l.Name] = i
+			}
+		}
+	}
+
+	inner := make([][][2]string, len(groups))
+	var cross [][2]string
+	for i, group := range groups {
+		names := lo.SliceToMap(group, func(l FuncLayer) (string, bool) {
+			return l.Name, true
+		})
+		for _, l := range group {
+			for _, callee := range l.Callees {
+				if names[callee] {
+					inner[i] = append(inner[i], [2]string{layerNodeID(i, l.Name), layerNodeID(i, callee)})
+				} else if j, ok := groupOf[callee]; ok {
+					cross = append(cross, [2]string{layerNodeID(i, l.Name), layerNodeID(j, callee)})
+				}
+			}
+		}
+	}
+
+	return inner, cross
+}
+
+func writeLayersText(w io.Writer, layers []FuncLayer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, group := range groupLayers(layers) {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "# %s\n", group[0].Group)
+		for _, l := range group {
+			line := fmt.Sprintf("%s\tlayer %d\t%s", l.Name, l.Layer, l.File)
+			if len(l.Callees) > 0 {
+				line += "\tcalls " + strings.Join(l.Callees, ", ")
+			}
+			if len(l.Cycle) > 0 {
+				line += "\tcycle " + strings.Join(l.Cycle, ", ")
+			}
+			fmt.Fprintln(tw, line)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// describeLayers layers funcs together and returns them in layer order, with
+// their callees and cycles taken from calls.
+func describeLayers(group string, funcs []*dst.FuncDecl, calls callInfo, fileOf func(*dst.FuncDecl) string, callsOnly bool) []FuncLayer {
+	funcNames := lo.SliceToMap(funcs, func(fn *dst.FuncDecl) (string, bool) {
+		return funcKey(fn), true
+	})
+	layers := assignLayers(buildCallGraph(funcs, funcNames, callsOnly), funcNames)
+
+	result := make([]FuncLayer, 0, len(funcs))
+	for _, fn := range funcs {
+		key := funcKey(fn)
+		result = append(result, FuncLayer{
+			Callees: calls.callees[key],
+			Cycle:   calls.cycles[key],
+			File:    fileOf(fn),
+			Group:   group,
+			Layer:   layers[key],
+			Name:    key,
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Layer != result[j].Layer {
+			return result[i].Layer > result[j].Layer
+		}
+
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// groupLayers splits layers into their groups, in order of first appearance.
+func groupLayers(layers []FuncLayer) [][]FuncLayer {
+	var groups [][]FuncLayer
+	index := make(map[string]int)
+	for _, l := range layers {
+		i, ok := index[l.Group]
+		if !ok {
+			i = len(groups)
+			index[l.Group] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], l)
+	}
+
+	return groups
+}
+
+// layerNodeID returns a graph node identifier for a function of a group. Dots
+// of method names are not valid in DOT and Mermaid identifiers.
+func layerNodeID(group int, name string) string {
+	return fmt.Sprintf("g%d_%s", group, strings.ReplaceAll(name, ".", "_"))
+}
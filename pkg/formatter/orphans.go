@@ -0,0 +1,431 @@
+package formatter
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/samber/lo"
+	"golang.org/x/tools/go/packages"
+)
+
+// orphanTarget identifies the file a method is moved into: the one declaring
+// its receiver type, in the same package clause and of the same kind.
+type orphanTarget struct {
+	pkg      string
+	test     bool
+	typeName string
+}
+
+// groupOrphanMethods groups the orphan methods by receiver type, each group
+// sorted like the methods of a local type. Receiver types follow their
+// declaration order in the package, or their first appearance in the file
+// without package mode.
+func (c *declCollector) groupOrphanMethods() []*dst.FuncDecl {
+	byType := make(map[string][]*dst.FuncDecl)
+	var typeNames []string
+	for _, fn := range c.orphanMethods {
+		typeName := getReceiverTypeName(fn)
+		if _, ok := byType[typeName]; !ok {
+			typeNames = append(typeNames, typeName)
+		}
+		byType[typeName] = append(byType[typeName], fn)
+	}
+
+	sort.SliceStable(typeNames, func(i, j int) bool {
+		posI, okI := c.packageTypes[typeNames[i]]
+		posJ, okJ := c.packageTypes[typeNames[j]]
+		if okI != okJ {
+			return okI
+		}
+
+		return okI && posI < posJ
+	})
+
+	result := make([]*dst.FuncDecl, 0, len(c.orphanMethods))
+	for _, typeName := range typeNames {
+		methods := byType[typeName]
+		sortFuncDeclsByExportabilityThenLayer(methods, c.layering)
+		result = append(result, c.interfaceMethods.group(methods)...)
+	}
+
+	return result
+}
+
+// moveOrphanMethods moves every method declared away from its receiver type
+// into the file declaring the type, together with the imports it uses. Methods
+// only move between writable files of the same package clause, both test files
+// or both not, without build constraints. A method stays if its imports would
+// clash with the imports of the target file, or if it selects from a name that
+// is neither local, nor declared in the package, nor an import whose package
+// name is known. Files losing or gaining methods verify against their source
+// after the move.
+func moveOrphanMethods(dir string, files []*sourceFile) error {
+	importNames := loadImportNames(dir, files)
+	globals := packageLevelNames(files)
+	targets := make(map[orphanTarget]*sourceFile)
+	declared := make(map[*sourceFile]map[string]bool)
+	for _, pf := range files {
+		if !pf.writable || buildConstraint(pf) != "" {
+			continue
+		}
+		declared[pf] = make(map[string]bool)
+		for _, decl := range pf.file.Decls {
+			gd, ok := decl.(*dst.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts, ok := spec.(*dst.TypeSpec)
+				if !ok {
+					continue
+				}
+				declared[pf][ts.Name.Name] = true
+				key := orphanTarget{pkg: pf.file.Name.Name, test: isTestFile(pf.path), typeName: ts.Name.Name}
+				if targets[key] == nil {
+					targets[key] = pf
+				}
+			}
+		}
+	}
+
+	changed := make(map[*sourceFile]bool)
+	for _, pf := range files {
+		if declared[pf] == nil || hasDotImport(pf.file) {
+			continue
+		}
+
+		var kept []dst.Decl
+		usedImports := make(map[string]bool)
+		for _, decl := range pf.file.Decls {
+			fn, ok := decl.(*dst.FuncDecl)
+			if !ok || fn.Recv == nil || declared[pf][getReceiverTypeName(fn)] {
+				kept = append(kept, decl)
+				continue
+			}
+			target := targets[orphanTarget{pkg: pf.file.Name.Name, test: isTestFile(pf.path), typeName: getReceiverTypeName(fn)}]
+			if target == nil {
+				kept = append(kept, decl)
+				continue
+			}
+			imports, used, ok := importsUsedBy(fn, pf.file, importNames, globals[pf.file.Name.Name])
+			if !ok || importsAny(target.file, used, importNames) || !addImports(target.file, imports, importNames) {
+				kept = append(kept, decl)
+				continue
+			}
+
+			target.file.Decls = append(target.file.Decls, fn)
+			moveLiteralTypes(fn, pf, target)
+			for _, spec := range imports {
+				usedImports[spec.Path.Value] = true
+			}
+			changed[pf] = true
+			changed[target] = true
+		}
+		pf.file.Decls = kept
+		removeUnusedImports(pf.file, usedImports, importNames)
+	}
+
+	for _, pf := range files {
+		if !changed[pf] {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := decorator.Fprint(&buf, pf.file); err != nil {
+			return fmt.Errorf("%s: print moved methods: %w", pf.path, err)
+		}
+		pf.baseline = buf.Bytes()
+	}
+
+	return nil
+}
+
+// addImports adds the import specs to f, unless one of them clashes with an
+// import of f: the same path under another name, or another path under the
+// same name. It reports whether the imports are available in f, and false if
+// the name of an import of f is unknown.
+func addImports(f *dst.File, imports []*dst.ImportSpec, importNames map[string]string) bool {
+	var missing []*dst.ImportSpec
+	for _, spec := range imports {
+		found := false
+		for _, existing := range f.Imports {
+			samePath := existing.Path.Value == spec.Path.Value
+			sameName := importName(existing, importNames) == importName(spec, importNames)
+			if importName(existing, importNames) == "" || samePath != sameName {
+				return false
+			}
+			found = found || samePath
+		}
+		if !found {
+			missing = append(missing, spec)
+		}
+	}
+	if len(missing) == 0 {
+		return true
+	}
+
+	var importDecl *dst.GenDecl
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*dst.GenDecl); ok && gd.Tok == token.IMPORT {
+			importDecl = gd
+
+			break
+		}
+	}
+	if importDecl == nil {
+		importDecl = &dst.GenDecl{Tok: token.IMPORT}
+		f.Decls = append([]dst.Decl{importDecl}, f.Decls...)
+	}
+	for _, spec := range missing {
+		added := &dst.ImportSpec{Path: &dst.BasicLit{Kind: token.STRING, Value: spec.Path.Value}}
+		if spec.Name != nil {
+			added.Name = dst.NewIdent(spec.Name.Name)
+		}
+		importDecl.Specs = append(importDecl.Specs, added)
+		f.Imports = append(f.Imports, added)
+	}
+	importDecl.Lparen = len(importDecl.Specs) > 1
+
+	return true
+}
+
+// importsAny reports whether f imports a package under one of the names, or
+// has an import whose package name is unknown.
+func importsAny(f *dst.File, names []string, importNames map[string]string) bool {
+	if len(names) == 0 {
+		return false
+	}
+
+	return lo.ContainsBy(f.Imports, func(spec *dst.ImportSpec) bool {
+		name := importName(spec, importNames)
+
+		return name == "" || slices.Contains(names, name)
+	})
+}
+
+// importsUsedBy returns the imports of f that the declaration references, and
+// the package-level names it selects from. It reports false if a name the
+// declaration selects from is not a local, an import or a name of globals, if
+// it could be an import whose package name is unknown, or if it is both a
+// local and an import, since references to it cannot be told apart without
+// scopes.
+func importsUsedBy(decl dst.Decl, f *dst.File, importNames map[string]string, globals map[string]bool) ([]*dst.ImportSpec, []string, bool) {
+	byName := make(map[string]*dst.ImportSpec)
+	unresolved := false
+	for _, spec := range f.Imports {
+		switch name := importName(spec, importNames); name {
+		case "":
+			unresolved = true
+		case "_":
+		default:
+			byName[name] = spec
+		}
+	}
+
+	local := declaredNames(decl)
+	used := make(map[string]bool)
+	var imports []*dst.ImportSpec
+	var names []string
+	ok := true
+	dst.Inspect(decl, func(n dst.Node) bool {
+		sel, isSel := n.(*dst.SelectorExpr)
+		if !isSel {
+			return true
+		}
+		ident, isIdent := sel.X.(*dst.Ident)
+		if !isIdent || used[ident.Name] {
+			return true
+		}
+		used[ident.Name] = true
+
+		spec := byName[ident.Name]
+		switch {
+		case local[ident.Name]:
+			ok = ok && spec == nil
+		case spec != nil:
+			imports = append(imports, spec)
+		case !unresolved && globals[ident.Name]:
+			names = append(names, ident.Name)
+		default:
+			ok = false
+		}
+
+		return true
+	})
+	if !ok {
+		return nil, nil, false
+	}
+
+	return imports, names, true
+}
+
+// removeUnusedImports removes the imports of f among paths, quoted, that f no
+// longer references.
+func removeUnusedImports(f *dst.File, paths map[string]bool, importNames map[string]string) {
+	if len(paths) == 0 {
+		return
+	}
+
+	referenced := make(map[string]bool)
+	for _, decl := range f.Decls {
+		dst.Inspect(decl, func(n dst.Node) bool {
+			if sel, ok := n.(*dst.SelectorExpr); ok {
+				if ident, ok := sel.X.(*dst.Ident); ok {
+					referenced[ident.Name] = true
+				}
+			}
+
+			return true
+		})
+	}
+
+	unused := func(spec *dst.ImportSpec) bool {
+		return paths[spec.Path.Value] && !referenced[importName(spec, importNames)]
+	}
+
+	var decls []dst.Decl
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*dst.GenDecl); ok && gd.Tok == token.IMPORT {
+			specs := lo.Reject(gd.Specs, func(spec dst.Spec, _ int) bool {
+				return unused(spec.(*dst.ImportSpec))
+			})
+			if len(specs) == 0 {
+				continue
+			}
+			if len(specs) < len(gd.Specs) {
+				gd.Specs = specs
+				gd.Lparen = len(specs) > 1
+			}
+		}
+		decls = append(decls, decl)
+	}
+	f.Decls = decls
+	f.Imports = lo.Reject(f.Imports, func(spec *dst.ImportSpec, _ int) bool {
+		return unused(spec)
+	})
+}
+
+func hasDotImport(f *dst.File) bool {
+	for _, spec := range f.Imports {
+		if spec.Name != nil && spec.Name.Name == "." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// importName returns the name an import is referenced by: its explicit name,
+// or else the name of the imported package, "" if unknown.
+func importName(spec *dst.ImportSpec, importNames map[string]string) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+
+	importPath, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return ""
+	}
+
+	return importNames[importPath]
+}
+
+// loadImportNames returns the package names of the paths imported by the
+// files, by import path. A path that cannot be loaded is left out, so the
+// name of its package is unknown.
+func loadImportNames(dir string, files []*sourceFile) map[string]string {
+	var importPaths []string
+	for _, pf := range files {
+		for _, spec := range pf.file.Imports {
+			if importPath, err := strconv.Unquote(spec.Path.Value); err == nil && !slices.Contains(importPaths, importPath) {
+				importPaths = append(importPaths, importPath)
+			}
+		}
+	}
+
+	names := make(map[string]string)
+	absDir, err := filepath.Abs(dir)
+	if err != nil || len(importPaths) == 0 {
+		return names
+	}
+
+	cfg := &packages.Config{
+		Mode: packages.NeedName,
+		Dir:  absDir,
+		Env:  append(os.Environ(), "GOPROXY=off"),
+	}
+	pkgs, err := packages.Load(cfg, importPaths...)
+	if err != nil {
+		return names
+	}
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) == 0 && pkg.Name != "" {
+			names[pkg.PkgPath] = pkg.Name
+		}
+	}
+
+	return names
+}
+
+// moveLiteralTypes hands the types resolved for the composite literals of a
+// moved declaration over to the file it moved to.
+func moveLiteralTypes(decl dst.Decl, from, to *sourceFile) {
+	if from.literals == nil {
+		return
+	}
+
+	dst.Inspect(decl, func(n dst.Node) bool {
+		if cl, ok := n.(*dst.CompositeLit); ok {
+			if typed, ok := from.literals[cl]; ok {
+				if to.literals == nil {
+					to.literals = make(literalTypes)
+				}
+				to.literals[cl] = typed
+				delete(from.literals, cl)
+			}
+		}
+
+		return true
+	})
+}
+
+// packageLevelNames returns the names declared at package level by the files,
+// by package clause.
+func packageLevelNames(files []*sourceFile) map[string]map[string]bool {
+	result := make(map[string]map[string]bool)
+	for _, pf := range files {
+		names := result[pf.file.Name.Name]
+		if names == nil {
+			names = make(map[string]bool)
+			result[pf.file.Name.Name] = names
+		}
+		for _, decl := range pf.file.Decls {
+			switch d := decl.(type) {
+			case *dst.FuncDecl:
+				if d.Recv == nil {
+					names[d.Name.Name] = true
+				}
+			case *dst.GenDecl:
+				for _, spec := range d.Specs {
+					switch sp := spec.(type) {
+					case *dst.TypeSpec:
+						names[sp.Name.Name] = true
+					case *dst.ValueSpec:
+						for _, name := range sp.Names {
+							names[name.Name] = true
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,624 @@
+package formatter
+
+import (
+	"errors"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/samber/lo"
+	"golang.org/x/mod/modfile"
+)
+
+var (
+	// knownOS and knownArch are the GOOS and GOARCH values a file name suffix
+	// restricts the build to (file_linux.go, file_windows_amd64_test.go).
+	knownArch = map[string]bool{
+		"386": true, "amd64": true, "amd64p32": true, "arm": true, "armbe": true, "arm64": true, "arm64be": true,
+		"loong64": true, "mips": true, "mipsle": true, "mips64": true, "mips64le": true, "mips64p32": true,
+		"mips64p32le": true, "ppc": true, "ppc64": true, "ppc64le": true, "riscv": true, "riscv64": true,
+		"s390": true, "s390x": true, "sparc": true, "sparc64": true, "wasm": true,
+	}
+	knownOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true, "hurd": true,
+		"illumos": true, "ios": true, "js": true, "linux": true, "nacl": true, "netbsd": true, "openbsd": true,
+		"plan9": true, "solaris": true, "wasip1": true, "windows": true, "zos": true,
+	}
+)
+
+// packageContext holds the struct definitions of a whole package. Literals are
+// converted and reordered using it, so a struct is never reordered while one of
+// its positional literals elsewhere in the package stays positional.
+type packageContext struct {
+	// imported holds the contexts of packages whose external test files
+	// (package foo_test) are formatted with this context, by import path.
+	imported map[string]*packageContext
+	// layers holds the architectural layers computed from the call graph of the
+	// package, by funcKey, if package layers are enabled.
+	layers      map[string]int
+	layersTests bool
+	// originalFieldOrder, pinnedStructs and sortedFieldOrder are keyed by
+	// structKey, since files built under different constraints may declare the
+	// same struct differently.
+	originalFieldOrder map[string][]string
+	pinnedStructs      map[string]bool
+	sortedFieldOrder   map[string][]string
+	// typeOrder holds the position of every type of the package, in file name
+	// and declaration order.
+	typeOrder map[string]int
+	// variants holds the keys of the declarations of every struct, by name.
+	variants map[string][]string
+}
+
+func newPackageContext() *packageContext {
+	return &packageContext{
+		imported:           make(map[string]*packageContext),
+		originalFieldOrder: make(map[string][]string),
+		pinnedStructs:      make(map[string]bool),
+		sortedFieldOrder:   make(map[string][]string),
+		typeOrder:          make(map[string]int),
+		variants:           make(map[string][]string),
+	}
+}
+
+// structsOf returns the original and sorted field orders and the pinned
+// structs seen from the file, by struct name. A struct declared differently
+// under several other build constraints has no field order, so its positional
+// literals are left alone and pin it.
+func (p *packageContext) structsOf(sf *sourceFile) (map[string][]string, map[string][]string, map[string]bool) {
+	original, sorted, pinned := p.structsFor(buildConstraint(sf))
+
+	for importPath, imported := range p.imported {
+		qualifier := findImportName(sf.file, importPath)
+		if qualifier == "" {
+			continue
+		}
+		importedOriginal, importedSorted, _ := imported.structsFor("")
+		for name, order := range importedOriginal {
+			original[qualifier+"."+name] = order
+			sorted[qualifier+"."+name] = importedSorted[name]
+		}
+	}
+
+	return original, sorted, pinned
+}
+
+// collectStructs records the original field order of all structs declared in
+// the files, keyed by structKey. Structs declared in files that are not written
+// are pinned. Named types defined from a struct (type B A, type B = A) share
+// its fields; their keys are returned mapped to the key of the struct they are
+// defined from.
+func (p *packageContext) collectStructs(files []*sourceFile, stable bool) map[string]string {
+	type definition struct {
+		constraint string
+		from       string
+		name       string
+	}
+	var definitions []definition
+
+	for _, pf := range files {
+		constraint := buildConstraint(pf)
+		for name, order := range collectOriginalFieldOrder(pf.file) {
+			key := p.addVariant(constraint, name)
+			p.originalFieldOrder[key] = order
+			if !pf.writable {
+				p.pinnedStructs[key] = true
+			}
+		}
+		for name, order := range collectStructDefinitions(pf.file, stable) {
+			p.sortedFieldOrder[structKey(constraint, name)] = order
+		}
+
+		dst.Inspect(pf.file, func(n dst.Node) bool {
+			ts, ok := n.(*dst.TypeSpec)
+			if !ok {
+				return true
+			}
+			if ident, ok := ts.Type.(*dst.Ident); ok {
+				definitions = append(definitions, definition{constraint: constraint, from: ident.Name, name: ts.Name.Name})
+			}
+
+			return true
+		})
+	}
+
+	derived := make(map[string]string)
+	for _, d := range definitions {
+		from := p.resolve(d.constraint, d.from)
+		if len(from) != 1 {
+			continue
+		}
+		key := p.addVariant(d.constraint, d.name)
+		derived[key] = from[0]
+		p.originalFieldOrder[key] = p.originalFieldOrder[from[0]]
+		p.sortedFieldOrder[key] = p.sortedFieldOrder[from[0]]
+	}
+
+	return derived
+}
+
+// structsFor returns the structs seen from files built under constraint, by
+// name.
+func (p *packageContext) structsFor(constraint string) (map[string][]string, map[string][]string, map[string]bool) {
+	original := make(map[string][]string)
+	sorted := make(map[string][]string)
+	pinned := make(map[string]bool)
+	for name := range p.variants {
+		keys := p.resolve(constraint, name)
+		if len(keys) != 1 {
+			original[name] = nil
+			continue
+		}
+		original[name] = p.originalFieldOrder[keys[0]]
+		sorted[name] = p.sortedFieldOrder[keys[0]]
+		pinned[name] = p.pinnedStructs[keys[0]]
+	}
+
+	return original, sorted, pinned
+}
+
+// addVariant records a declaration of the struct name in files built under
+// constraint and returns its key.
+func (p *packageContext) addVariant(constraint, name string) string {
+	key := structKey(constraint, name)
+	if !slices.Contains(p.variants[name], key) {
+		p.variants[name] = append(p.variants[name], key)
+	}
+
+	return key
+}
+
+// collectLayers computes the architectural layers of the functions of the
+// package from the call graph of all its files. Test files are left out unless
+// withTests is set.
+func (p *packageContext) collectLayers(files []*sourceFile, withTests, callsOnly bool) {
+	var funcs []*dst.FuncDecl
+	for _, pf := range files {
+		if !withTests && isTestFile(pf.path) {
+			continue
+		}
+		for _, decl := range pf.file.Decls {
+			if fn, ok := decl.(*dst.FuncDecl); ok {
+				funcs = append(funcs, fn)
+			}
+		}
+	}
+
+	funcNames := lo.SliceToMap(funcs, func(fn *dst.FuncDecl) (string, bool) {
+		return funcKey(fn), true
+	})
+	p.layers = assignLayers(buildCallGraph(funcs, funcNames, callsOnly), funcNames)
+	p.layersTests = withTests
+}
+
+// collectTypeOrder records the declaration order of the types of the package.
+func (p *packageContext) collectTypeOrder(files []*sourceFile) {
+	for _, pf := range files {
+		for _, decl := range pf.file.Decls {
+			gd, ok := decl.(*dst.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				if ts, ok := spec.(*dst.TypeSpec); ok {
+					if _, seen := p.typeOrder[ts.Name.Name]; !seen {
+						p.typeOrder[ts.Name.Name] = len(p.typeOrder)
+					}
+				}
+			}
+		}
+	}
+}
+
+// computeSortedFieldOrder makes literals of pinned structs follow the original
+// field order instead of the sorted one.
+func (p *packageContext) computeSortedFieldOrder(derived map[string]string) {
+	for name, from := range derived {
+		if p.pinnedStructs[name] {
+			p.pinnedStructs[from] = true
+		}
+	}
+
+	for name, order := range p.originalFieldOrder {
+		structName := name
+		if from, ok := derived[name]; ok {
+			structName = from
+		}
+		if p.pinnedStructs[structName] {
+			p.sortedFieldOrder[name] = order
+		}
+	}
+}
+
+// layersOf returns the package layers of the functions of the file, or nil if
+// the file is layered on its own.
+func (p *packageContext) layersOf(filePath string) map[string]int {
+	if !p.layersTests && isTestFile(filePath) {
+		return nil
+	}
+
+	return p.layers
+}
+
+// resolve returns the keys of the declarations the struct name can refer to
+// in a file built under constraint: the declaration under the same
+// constraint, or else the unconstrained one, or else all of them.
+func (p *packageContext) resolve(constraint, name string) []string {
+	keys := p.variants[name]
+	for _, key := range []string{structKey(constraint, name), name} {
+		if slices.Contains(keys, key) {
+			return []string{key}
+		}
+	}
+
+	return keys
+}
+
+// FormatPackage formats all Go files of the package in dir together. Positional
+// literals of package structs are converted to keyed literals in every file
+// before the structs are reordered. A struct is not reordered if any of its
+// positional literals cannot be converted, e.g. because it is in an excluded or
+// generated file.
+func FormatPackage(dir string, opts Options) error {
+	opts.PackageMode = true
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	return formatPackage(dir, "", opts)
+}
+
+// formatPackage formats the files in dir, which are loaded together for package
+// mode and type-aware mode. If only is set, just that file is written and the
+// other files are treated as read-only. A panic in any step is returned as an
+// *InternalError.
+func formatPackage(dir, only string, opts Options) (err error) {
+	var files []*sourceFile
+	pass := "loadPackageFiles"
+	defer func() {
+		if r := recover(); r != nil {
+			err = newPackageInternalError(dir, files, pass, r, opts)
+		}
+	}()
+
+	files, incomplete, loadErr := loadPackageFiles(dir, only, opts)
+	if files == nil {
+		return loadErr
+	}
+
+	// The declarations of a file that does not parse are unknown, so methods
+	// are only moved within complete packages.
+	if opts.MoveOrphanMethods && !incomplete {
+		pass = "moveOrphanMethods"
+		if err := moveOrphanMethods(dir, files); err != nil {
+			return err
+		}
+	}
+
+	var contexts map[string]*packageContext
+	if opts.PackageMode {
+		pass = "buildPackageContexts"
+		contexts = buildPackageContexts(files, detectPackageImportPath(dir), incomplete, opts)
+	}
+
+	// Format every file before writing any, so that a failure never leaves the
+	// package with a reordered struct and unconverted literals. All files are
+	// attempted, to report every failure at once.
+	formatted := make(map[*sourceFile][]byte)
+	var errs []error
+	for _, pf := range files {
+		if !pf.writable {
+			continue
+		}
+
+		if contexts != nil {
+			pf.pkg = contexts[pf.file.Name.Name]
+		}
+
+		result, err := formatParsedFile(pf, opts)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		formatted[pf] = result
+	}
+	if len(errs) > 0 {
+		return errors.Join(append([]error{loadErr}, errs...)...)
+	}
+
+	pass = "writeFormatted"
+	for _, pf := range files {
+		if !pf.writable {
+			continue
+		}
+
+		if err := writeFormatted(pf.path, pf.src, formatted[pf], opts); err != nil {
+			return err
+		}
+	}
+
+	// Files that do not parse are left alone and reported once the rest of
+	// the package is written.
+	return loadErr
+}
+
+// buildPackageContexts builds a context per package clause found in the
+// directory. The external test package gets access to the structs of the
+// package under test through its import path. With package layers, every
+// context also holds the layers of its functions. In an incomplete package,
+// every struct keeps its field order.
+func buildPackageContexts(files []*sourceFile, importPath string, incomplete bool, opts Options) map[string]*packageContext {
+	contexts := make(map[string]*packageContext)
+	filesByPackage := make(map[string][]*sourceFile)
+	for _, pf := range files {
+		name := pf.file.Name.Name
+		if contexts[name] == nil {
+			contexts[name] = newPackageContext()
+		}
+		filesByPackage[name] = append(filesByPackage[name], pf)
+	}
+
+	derivedByPackage := make(map[string]map[string]string)
+	for name, pkgFiles := range filesByPackage {
+		derivedByPackage[name] = contexts[name].collectStructs(pkgFiles, opts.Stable)
+	}
+
+	if importPath != "" {
+		for name, ctx := range contexts {
+			if base, ok := contexts[strings.TrimSuffix(name, "_test")]; ok && base != ctx {
+				ctx.imported[importPath] = base
+			}
+		}
+	}
+
+	for name, pkgFiles := range filesByPackage {
+		ctx := contexts[name]
+		for _, pf := range pkgFiles {
+			original, _, _ := ctx.structsOf(pf)
+			collectPinnedStructs(pf.file, original, pf.writable, func(typeName string) {
+				if qualifier, structName, ok := strings.Cut(typeName, "."); ok {
+					for importPath, imported := range ctx.imported {
+						if findImportName(pf.file, importPath) == qualifier {
+							for _, key := range imported.resolve("", structName) {
+								imported.pinnedStructs[key] = true
+							}
+						}
+					}
+
+					return
+				}
+				for _, key := range ctx.resolve(buildConstraint(pf), typeName) {
+					ctx.pinnedStructs[key] = true
+				}
+			})
+		}
+	}
+
+	// A file that does not parse may hold positional literals of any struct.
+	if incomplete {
+		for _, ctx := range contexts {
+			for key := range ctx.originalFieldOrder {
+				ctx.pinnedStructs[key] = true
+			}
+		}
+	}
+
+	for name, ctx := range contexts {
+		ctx.computeSortedFieldOrder(derivedByPackage[name])
+		ctx.collectTypeOrder(filesByPackage[name])
+		if opts.PackageLayers {
+			ctx.collectLayers(filesByPackage[name], opts.PackageLayersTests, opts.LayerCallsOnly)
+		}
+	}
+
+	return contexts
+}
+
+// collectPinnedStructs reports structs that have positional literals which
+// cannot be converted to keyed ones: literals in files that are not written,
+// and literals whose element count does not match the field count.
+func collectPinnedStructs(f *dst.File, structDefs map[string][]string, writable bool, pin func(typeName string)) {
+	var visit func(cl *dst.CompositeLit, inherited string)
+	visit = func(cl *dst.CompositeLit, inherited string) {
+		typeName := inherited
+		if cl.Type != nil {
+			typeName = literalTypeName(cl.Type)
+		}
+		if fields, ok := structDefs[typeName]; ok && isPositionalLiteral(cl) && (!writable || len(cl.Elts) != len(fields)) {
+			pin(typeName)
+		}
+
+		elemType := elementTypeName(cl.Type)
+		for _, elt := range cl.Elts {
+			if kv, ok := elt.(*dst.KeyValueExpr); ok {
+				elt = kv.Value
+			}
+			if child, ok := elt.(*dst.CompositeLit); ok {
+				visit(child, elemType)
+			}
+		}
+	}
+
+	dst.Inspect(f, func(n dst.Node) bool {
+		cl, ok := n.(*dst.CompositeLit)
+		if !ok {
+			return true
+		}
+		visit(cl, "")
+
+		return false
+	})
+}
+
+// loadPackageFiles parses the Go files in dir. Files that are not written
+// (excluded, or other than only) are loaded read-only. A file that does not
+// parse is left out and reported, unless it would not have been written; the
+// package is then incomplete.
+func loadPackageFiles(dir, only string, opts Options) (files []*sourceFile, incomplete bool, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var fileNames []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".go") {
+			fileNames = append(fileNames, entry.Name())
+		}
+	}
+	if len(fileNames) == 0 {
+		return nil, false, nil
+	}
+
+	var typed map[string]*sourceFile
+	if opts.TypeAware {
+		if typed, err = loadTypedFiles(dir, opts.Stable); err != nil {
+			return nil, false, err
+		}
+	}
+
+	var errs []error
+	for _, name := range fileNames {
+		filePath := filepath.Join(dir, name)
+		readOnly := matchesAnyPattern(filePath, opts.ExcludePatterns) || only != "" && filepath.Clean(only) != filepath.Clean(filePath)
+		sf, err := loadSourceFile(filePath, typed)
+		if err != nil {
+			incomplete = true
+			if !readOnly {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		sf.writable = !readOnly && !isGeneratedFile(sf.file)
+		files = append(files, sf)
+	}
+
+	return files, incomplete, errors.Join(errs...)
+}
+
+// buildConstraint returns the conditions a file is only built under, from its
+// //go:build line and GOOS or GOARCH file name suffix, or "" if it is always
+// built.
+func buildConstraint(pf *sourceFile) string {
+	var parts []string
+	for _, decs := range []dst.Decorations{pf.file.Decs.Start, pf.file.Decs.Package} {
+		for _, line := range decs {
+			if strings.HasPrefix(line, "//go:build") || strings.HasPrefix(line, "// +build") {
+				parts = append(parts, line)
+			}
+		}
+	}
+
+	name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(pf.path), ".go"), "_test")
+	suffixes := strings.Split(name, "_")[1:]
+	switch n := len(suffixes); {
+	case n >= 2 && knownOS[suffixes[n-2]] && knownArch[suffixes[n-1]]:
+		parts = append(parts, suffixes[n-2]+"_"+suffixes[n-1])
+	case n >= 1 && (knownOS[suffixes[n-1]] || knownArch[suffixes[n-1]]):
+		parts = append(parts, suffixes[n-1])
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func detectPackageImportPath(dir string) string {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+
+	modPath := findGoMod(filepath.Join(absDir, "go.mod"))
+	if modPath == "" {
+		return ""
+	}
+
+	data, err := os.ReadFile(modPath)
+	if err != nil {
+		return ""
+	}
+
+	mf, err := modfile.Parse(modPath, data, nil)
+	if err != nil || mf.Module == nil {
+		return ""
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(modPath), absDir)
+	if err != nil {
+		return ""
+	}
+
+	return path.Join(mf.Module.Mod.Path, filepath.ToSlash(rel))
+}
+
+func elementTypeName(t dst.Expr) string {
+	switch e := t.(type) {
+	case *dst.ArrayType:
+		return literalTypeName(e.Elt)
+	case *dst.MapType:
+		return literalTypeName(e.Value)
+	}
+
+	return ""
+}
+
+// findImportName returns the name under which importPath is imported in f, or
+// an empty string if it is not imported or imported with a dot or blank name.
+func findImportName(f *dst.File, importPath string) string {
+	for _, imp := range f.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || p != importPath {
+			continue
+		}
+		if imp.Name != nil {
+			if imp.Name.Name == "." || imp.Name.Name == "_" {
+				return ""
+			}
+
+			return imp.Name.Name
+		}
+
+		return path.Base(p)
+	}
+
+	return ""
+}
+
+// loadSourceFile returns the type-checked version of the file if there is one,
+// and parses it otherwise.
+func loadSourceFile(filePath string, typed map[string]*sourceFile) (*sourceFile, error) {
+	if absPath, err := filepath.Abs(filePath); err == nil {
+		if sf, ok := typed[absPath]; ok {
+			sf.path = filePath
+
+			return sf, nil
+		}
+	}
+
+	src, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := parseSource(filePath, src)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sourceFile{file: f, path: filePath, src: src}, nil
+}
+
+// structKey returns the key of the struct name declared in files built under
+// constraint.
+func structKey(constraint, name string) string {
+	if constraint == "" {
+		return name
+	}
+
+	return constraint + "|" + name
+}
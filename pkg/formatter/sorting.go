@@ -2,13 +2,60 @@ package formatter
 
 import (
 	"go/token"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 
 	"github.com/dave/dst"
 	"github.com/samber/lo"
 )
 
-func sortDeclsByExportabilityThenLayer(decls []dst.Decl) {
+// typeOrdering describes how types are laid out: by category, then within each
+// category.
+type typeOrdering struct {
+	categories []string
+	// fileName is used to find the primary type.
+	fileName string
+	within   string
+}
+
+func splitAndGroupTypeDecls(typeDecls []*dst.GenDecl, ordering typeOrdering) []dst.Decl {
+	categories := make(map[string][]dst.Decl)
+	for _, gd := range splitTypeDecls(typeDecls) {
+		category := categorizeType(gd.Specs[0].(*dst.TypeSpec), ordering.categories)
+		categories[category] = append(categories[category], gd)
+	}
+
+	var result []dst.Decl
+	for _, category := range ordering.categories {
+		group := categories[category]
+		sortTypeGroup(group, ordering)
+		result = appendTypeGroup(result, group)
+	}
+
+	return result
+}
+
+// sortTypeGroup orders the types of one category by ordering.within. Stable
+// mode does not skip it: the order is only set by an explicit TypeSort.
+func sortTypeGroup(group []dst.Decl, ordering typeOrdering) {
+	switch ordering.within {
+	case TypeSortAlphabetical:
+		sort.SliceStable(group, func(i, j int) bool {
+			return declTypeName(group[i]) < declTypeName(group[j])
+		})
+	case TypeSortPrimary:
+		primary := primaryTypeKey(ordering.fileName)
+		sort.SliceStable(group, func(i, j int) bool {
+			return primaryTypeKey(declTypeName(group[i])) == primary && primaryTypeKey(declTypeName(group[j])) != primary
+		})
+	case TypeSortDependency:
+		copy(group, sortTypesByDependency(group))
+	}
+}
+
+func sortDeclsByExportabilityThenLayer(decls []dst.Decl, l layering) {
 	exported, unexported := lo.FilterReject(decls, func(d dst.Decl, _ int) bool {
 		if fn, ok := d.(*dst.FuncDecl); ok {
 			return isExported(fn.Name.Name)
@@ -16,21 +63,49 @@ func sortDeclsByExportabilityThenLayer(decls []dst.Decl) {
 
 		return true
 	})
-	sortDeclsByLayer(exported)
-	sortDeclsByLayer(unexported)
+	sortDeclsByLayer(exported, l)
+	sortDeclsByLayer(unexported, l)
 	copy(decls, append(exported, unexported...))
+
+	if l.funcSort == FuncSortStepDown {
+		funcs := lo.FilterMap(decls, func(d dst.Decl, _ int) (*dst.FuncDecl, bool) {
+			fn, ok := d.(*dst.FuncDecl)
+
+			return fn, ok
+		})
+		if len(funcs) == len(decls) {
+			sortFuncsStepDown(funcs, l)
+			for i, fn := range funcs {
+				decls[i] = fn
+			}
+		}
+	}
 }
 
-func sortFuncDeclsByExportabilityThenLayer(funcs []*dst.FuncDecl) {
+func sortFuncDeclsByExportabilityThenLayer(funcs []*dst.FuncDecl, l layering) {
 	exported, unexported := lo.FilterReject(funcs, func(fn *dst.FuncDecl, _ int) bool {
 		return isExported(fn.Name.Name)
 	})
-	sortFuncsByLayer(exported)
-	sortFuncsByLayer(unexported)
+	sortFuncsByLayer(exported, l)
+	sortFuncsByLayer(unexported, l)
 	copy(funcs, append(exported, unexported...))
+
+	if l.funcSort == FuncSortStepDown {
+		sortFuncsStepDown(funcs, l)
+	}
 }
 
-func sortSpecsByExportabilityThenName(specs []dst.Spec) {
+// sortSpecsByExportabilityThenName sorts specs by export group, type and name.
+// In stable mode, types are ordered by first appearance and names are not
+// compared.
+func sortSpecsByExportabilityThenName(specs []dst.Spec, stable bool) {
+	typeRank := make(map[string]int)
+	for _, spec := range specs {
+		if typeName := getSpecTypeName(spec); !lo.HasKey(typeRank, typeName) {
+			typeRank[typeName] = len(typeRank)
+		}
+	}
+
 	sort.SliceStable(specs, func(i, j int) bool {
 		nameI := getSpecFirstName(specs[i])
 		nameJ := getSpecFirstName(specs[j])
@@ -41,6 +116,9 @@ func sortSpecsByExportabilityThenName(specs []dst.Spec) {
 		}
 		typeI := getSpecTypeName(specs[i])
 		typeJ := getSpecTypeName(specs[j])
+		if stable {
+			return typeRank[typeI] < typeRank[typeJ]
+		}
 		if typeI != typeJ {
 			return typeI < typeJ
 		}
@@ -49,35 +127,51 @@ func sortSpecsByExportabilityThenName(specs []dst.Spec) {
 	})
 }
 
-func splitAndGroupTypeDecls(typeDecls []*dst.GenDecl) []dst.Decl {
-	var simpleTypes, funcInterfaces, nonFuncInterfaces, structs []dst.Decl
+// sortTypesByDependency orders types so that every type comes before the types
+// it uses, keeping the original order where there is no dependency. Cycles are
+// broken by the original order.
+func sortTypesByDependency(group []dst.Decl) []dst.Decl {
+	index := make(map[string]int)
+	for i, d := range group {
+		index[declTypeName(d)] = i
+	}
 
-	for _, gd := range typeDecls {
-		if len(gd.Specs) == 0 {
-			continue
-		}
-		if len(gd.Specs) == 1 {
-			categorizeType(gd, gd.Specs[0].(*dst.TypeSpec), &simpleTypes, &funcInterfaces, &nonFuncInterfaces, &structs)
-			continue
+	users := make([]int, len(group))
+	uses := make([][]int, len(group))
+	for i, d := range group {
+		for _, name := range referencedTypeNames(d) {
+			if j, ok := index[name]; ok && j != i {
+				uses[i] = append(uses[i], j)
+				users[j]++
+			}
 		}
-		for i, spec := range gd.Specs {
-			ts := spec.(*dst.TypeSpec)
-			newGd := &dst.GenDecl{
-				Tok:   token.TYPE,
-				Specs: []dst.Spec{spec},
+	}
+
+	result := make([]dst.Decl, 0, len(group))
+	done := make([]bool, len(group))
+	for len(result) < len(group) {
+		next := -1
+		for i := range group {
+			if !done[i] && users[i] == 0 {
+				next = i
+				break
 			}
-			if i == 0 {
-				newGd.Decs = gd.Decs
+		}
+		if next == -1 {
+			for i := range group {
+				if !done[i] {
+					next = i
+					break
+				}
 			}
-			categorizeType(newGd, ts, &simpleTypes, &funcInterfaces, &nonFuncInterfaces, &structs)
 		}
-	}
 
-	var result []dst.Decl
-	result = appendTypeGroup(result, simpleTypes)
-	result = appendTypeGroup(result, funcInterfaces)
-	result = appendTypeGroup(result, nonFuncInterfaces)
-	result = appendTypeGroup(result, structs)
+		done[next] = true
+		result = append(result, group[next])
+		for _, j := range uses[next] {
+			users[j]--
+		}
+	}
 
 	return result
 }
@@ -93,19 +187,42 @@ func appendTypeGroup(result, group []dst.Decl) []dst.Decl {
 	return result
 }
 
-func categorizeType(gd *dst.GenDecl, ts *dst.TypeSpec, simpleTypes, funcInterfaces, nonFuncInterfaces, structs *[]dst.Decl) {
+// categorizeType returns the category of the type among categories. Aliases
+// and constraint interfaces fall into the category of their type expression
+// unless their own category is listed.
+func categorizeType(ts *dst.TypeSpec, categories []string) string {
+	if ts.Assign && slices.Contains(categories, TypeCategoryAliases) {
+		return TypeCategoryAliases
+	}
+
 	switch t := ts.Type.(type) {
 	case *dst.StructType:
-		*structs = append(*structs, gd)
+		return TypeCategoryStructs
 	case *dst.InterfaceType:
-		if isFuncInterface(t) {
-			*funcInterfaces = append(*funcInterfaces, gd)
-		} else {
-			*nonFuncInterfaces = append(*nonFuncInterfaces, gd)
+		switch {
+		case isConstraintInterface(t) && slices.Contains(categories, TypeCategoryConstraints):
+			return TypeCategoryConstraints
+		case isFuncInterface(t):
+			return TypeCategoryFuncInterfaces
+		default:
+			return TypeCategoryInterfaces
 		}
 	default:
-		*simpleTypes = append(*simpleTypes, gd)
+		return TypeCategorySimple
+	}
+}
+
+func declTypeName(d dst.Decl) string {
+	gd, ok := d.(*dst.GenDecl)
+	if !ok || len(gd.Specs) != 1 {
+		return ""
+	}
+	ts, ok := gd.Specs[0].(*dst.TypeSpec)
+	if !ok {
+		return ""
 	}
+
+	return ts.Name.Name
 }
 
 func getSpecTypeName(spec dst.Spec) string {
@@ -117,7 +234,52 @@ func getSpecTypeName(spec dst.Spec) string {
 	return extractTypeName(vs.Type)
 }
 
-func sortDeclsByLayer(decls []dst.Decl) {
+// primaryTypeKey normalises a file or type name for matching the primary type:
+// http_server.go and HTTPServer both become "httpserver".
+func primaryTypeKey(name string) string {
+	name = strings.TrimSuffix(filepath.Base(name), ".go")
+	name = strings.TrimSuffix(name, "_test")
+
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// referencedTypeNames returns the unqualified type names used in the
+// definition of the declared type, without duplicates.
+func referencedTypeNames(d dst.Decl) []string {
+	gd := d.(*dst.GenDecl)
+	ts := gd.Specs[0].(*dst.TypeSpec)
+
+	var names []string
+	seen := make(map[string]bool)
+	var visit func(n dst.Node) bool
+	visit = func(n dst.Node) bool {
+		switch node := n.(type) {
+		case *dst.Field:
+			// Skip field and method names.
+			dst.Inspect(node.Type, visit)
+
+			return false
+		case *dst.SelectorExpr:
+			return false
+		case *dst.Ident:
+			if node.Path == "" && !seen[node.Name] {
+				seen[node.Name] = true
+				names = append(names, node.Name)
+			}
+		}
+
+		return true
+	}
+	dst.Inspect(ts.Type, visit)
+
+	return names
+}
+
+func sortDeclsByLayer(decls []dst.Decl, l layering) {
+	if l.keepsOrder() {
+		return
+	}
+
 	funcs := lo.FilterMap(decls, func(d dst.Decl, _ int) (*dst.FuncDecl, bool) {
 		fn, ok := d.(*dst.FuncDecl)
 
@@ -128,12 +290,7 @@ func sortDeclsByLayer(decls []dst.Decl) {
 		return
 	}
 
-	funcNames := lo.SliceToMap(funcs, func(fn *dst.FuncDecl) (string, bool) {
-		return fn.Name.Name, true
-	})
-
-	callGraph := buildCallGraph(funcs, funcNames)
-	layers := assignLayers(callGraph, funcNames)
+	layers := l.layers(funcs)
 
 	sort.SliceStable(decls, func(i, j int) bool {
 		fnI, okI := decls[i].(*dst.FuncDecl)
@@ -141,7 +298,7 @@ func sortDeclsByLayer(decls []dst.Decl) {
 		if !okI || !okJ {
 			return false
 		}
-		layerI, layerJ := layers[fnI.Name.Name], layers[fnJ.Name.Name]
+		layerI, layerJ := layers[funcKey(fnI)], layers[funcKey(fnJ)]
 		if layerI != layerJ {
 			return layerI > layerJ
 		}
@@ -168,20 +325,15 @@ func sortFuncDeclsByName(funcs []*dst.FuncDecl) {
 	})
 }
 
-func sortFuncsByLayer(funcs []*dst.FuncDecl) {
-	if len(funcs) <= 1 {
+func sortFuncsByLayer(funcs []*dst.FuncDecl, l layering) {
+	if len(funcs) <= 1 || l.keepsOrder() {
 		return
 	}
 
-	funcNames := lo.SliceToMap(funcs, func(fn *dst.FuncDecl) (string, bool) {
-		return fn.Name.Name, true
-	})
-
-	callGraph := buildCallGraph(funcs, funcNames)
-	layers := assignLayers(callGraph, funcNames)
+	layers := l.layers(funcs)
 
 	sort.SliceStable(funcs, func(i, j int) bool {
-		layerI, layerJ := layers[funcs[i].Name.Name], layers[funcs[j].Name.Name]
+		layerI, layerJ := layers[funcKey(funcs[i])], layers[funcKey(funcs[j])]
 		if layerI != layerJ {
 			return layerI > layerJ
 		}
@@ -189,3 +341,70 @@ func sortFuncsByLayer(funcs []*dst.FuncDecl) {
 		return funcs[i].Name.Name < funcs[j].Name.Name
 	})
 }
+
+// sortFuncsStepDown reorders funcs, already sorted by exportability and layer,
+// by the step-down rule: every function is followed by the functions it calls
+// that are not placed yet, depth first, in order of first call. Functions are
+// visited in their current order, so the traversal starts from the exported
+// ones. As an explicitly selected order, it also applies in stable mode.
+func sortFuncsStepDown(funcs []*dst.FuncDecl, l layering) {
+	if len(funcs) <= 1 {
+		return
+	}
+
+	byKey := make(map[string]*dst.FuncDecl)
+	for _, fn := range funcs {
+		if _, ok := byKey[funcKey(fn)]; !ok {
+			byKey[funcKey(fn)] = fn
+		}
+	}
+	funcNames := lo.MapValues(byKey, func(*dst.FuncDecl, string) bool {
+		return true
+	})
+	callGraph := buildCallGraph(funcs, funcNames, l.callsOnly)
+
+	result := make([]*dst.FuncDecl, 0, len(funcs))
+	placed := make(map[*dst.FuncDecl]bool)
+	var visit func(fn *dst.FuncDecl)
+	visit = func(fn *dst.FuncDecl) {
+		if placed[fn] {
+			return
+		}
+		placed[fn] = true
+		result = append(result, fn)
+		for _, callee := range callGraph[funcKey(fn)] {
+			visit(byKey[callee])
+		}
+	}
+	for _, fn := range funcs {
+		visit(fn)
+	}
+
+	copy(funcs, result)
+}
+
+// splitTypeDecls splits type blocks into declarations of a single type. The
+// block's decorations go to its first type.
+func splitTypeDecls(typeDecls []*dst.GenDecl) []*dst.GenDecl {
+	var result []*dst.GenDecl
+	for _, gd := range typeDecls {
+		if len(gd.Specs) <= 1 {
+			if len(gd.Specs) == 1 {
+				result = append(result, gd)
+			}
+			continue
+		}
+		for i, spec := range gd.Specs {
+			newGd := &dst.GenDecl{
+				Tok:   token.TYPE,
+				Specs: []dst.Spec{spec},
+			}
+			if i == 0 {
+				newGd.Decs = gd.Decs
+			}
+			result = append(result, newGd)
+		}
+	}
+
+	return result
+}
@@ -25,6 +25,48 @@ func addSpaceBeforeComments(f *dst.File) {
 	})
 }
 
+func collapseFuncSignatures(f *dst.File) {
+	dst.Inspect(f, func(n dst.Node) bool {
+		switch node := n.(type) {
+		case *dst.FuncDecl:
+			if node.Type != nil {
+				collapseFuncType(node.Type)
+			}
+		case *dst.FuncLit:
+			if node.Type != nil {
+				collapseFuncType(node.Type)
+			}
+		case *dst.TypeSpec:
+			if ft, ok := node.Type.(*dst.FuncType); ok {
+				collapseFuncType(ft)
+			}
+		case *dst.InterfaceType:
+			if node.Methods != nil {
+				for _, method := range node.Methods.List {
+					if ft, ok := method.Type.(*dst.FuncType); ok {
+						collapseFuncType(ft)
+					}
+				}
+			}
+		}
+
+		return true
+	})
+}
+
+func collapseFuncType(ft *dst.FuncType) {
+	if ft.Params != nil {
+		collapseFieldList(ft.Params)
+	}
+	if ft.Results != nil {
+		collapseFieldList(ft.Results)
+	}
+}
+
+func hasLineComment(stmt dst.Stmt) bool {
+	return hasDocComment(stmt.Decorations().Start)
+}
+
 func removeBlankLinesBetweenCases(f *dst.File) {
 	dst.Inspect(f, func(n dst.Node) bool {
 		switch stmt := n.(type) {
@@ -67,6 +109,24 @@ func addSpaceBeforeReturns(f *dst.File) {
 	})
 }
 
+func collapseFieldList(fl *dst.FieldList) {
+	// Remove newlines from opening paren
+	fl.Decs.Opening = nil
+
+	for i, field := range fl.List {
+		// First field: no newline before
+		if i == 0 {
+			field.Decs.Before = dst.None
+		} else {
+			// Subsequent fields: space only (comma handled automatically)
+			field.Decs.Before = dst.None
+		}
+
+		// No newline after any field
+		field.Decs.After = dst.None
+	}
+}
+
 func expandOneLineFunctions(f *dst.File) {
 	dst.Inspect(f, func(n dst.Node) bool {
 		fn, ok := n.(*dst.FuncDecl)
@@ -79,10 +139,12 @@ func expandOneLineFunctions(f *dst.File) {
 	})
 }
 
-func hasLineComment(stmt dst.Stmt) bool {
-	decs := stmt.Decorations()
+func hasDocComment(decs dst.Decorations) bool {
+	return len(decs) > 0 && strings.HasPrefix(decs[0], "//")
+}
 
-	return len(decs.Start) > 0 && strings.HasPrefix(decs.Start[0], "//")
+func isCommentLine(line string) bool {
+	return strings.HasPrefix(line, "//") || strings.HasPrefix(line, "/*")
 }
 
 func normalizeCaseSpacing(stmts []dst.Stmt) {
@@ -127,58 +189,3 @@ func normalizeSpacing(f *dst.File) {
 		return true
 	})
 }
-
-func collapseFuncSignatures(f *dst.File) {
-	dst.Inspect(f, func(n dst.Node) bool {
-		switch node := n.(type) {
-		case *dst.FuncDecl:
-			if node.Type != nil {
-				collapseFuncType(node.Type)
-			}
-		case *dst.FuncLit:
-			if node.Type != nil {
-				collapseFuncType(node.Type)
-			}
-		case *dst.TypeSpec:
-			if ft, ok := node.Type.(*dst.FuncType); ok {
-				collapseFuncType(ft)
-			}
-		case *dst.InterfaceType:
-			if node.Methods != nil {
-				for _, method := range node.Methods.List {
-					if ft, ok := method.Type.(*dst.FuncType); ok {
-						collapseFuncType(ft)
-					}
-				}
-			}
-		}
-
-		return true
-	})
-}
-
-func collapseFuncType(ft *dst.FuncType) {
-	if ft.Params != nil {
-		collapseFieldList(ft.Params)
-	}
-	if ft.Results != nil {
-		collapseFieldList(ft.Results)
-	}
-}
-
-func collapseFieldList(fl *dst.FieldList) {
-	// Remove newlines from opening paren
-	fl.Decs.Opening = nil
-
-	for i, field := range fl.List {
-		// First field: no newline before
-		if i == 0 {
-			field.Decs.Before = dst.None
-		} else {
-			// Subsequent fields: space only (comma handled automatically)
-			field.Decs.Before = dst.None
-		}
-		// No newline after any field
-		field.Decs.After = dst.None
-	}
-}
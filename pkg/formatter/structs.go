@@ -6,17 +6,97 @@ import (
 	"github.com/dave/dst"
 )
 
-func reorderStructFields(f *dst.File) {
+func convertPositionalToKeyed(f *dst.File, structDefs map[string][]string) {
 	dst.Inspect(f, func(n dst.Node) bool {
-		if st, ok := n.(*dst.StructType); ok {
-			reorderFields(st)
+		cl, ok := n.(*dst.CompositeLit)
+		if !ok {
+			return true
 		}
 
-		return true
+		// Process this literal and all nested children
+		processCompositeLit(cl, nil, structDefs)
+
+		// Don't let dst.Inspect descend into children - we handle them
+		return false
+	})
+}
+
+func reorderStructLiterals(f *dst.File, structDefs map[string][]string) {
+	dst.Inspect(f, func(n dst.Node) bool {
+		cl, ok := n.(*dst.CompositeLit)
+		if !ok {
+			return true
+		}
+
+		// Process this literal and all nested children for reordering
+		reorderCompositeLitRecursive(cl, nil, structDefs)
+
+		// Don't let dst.Inspect descend into children - we handle them
+		return false
 	})
 }
 
-func collectStructDefinitions(f *dst.File) map[string][]string {
+func processCompositeLit(cl *dst.CompositeLit, inheritedFieldNames []string, structDefs map[string][]string) {
+	// Determine field names for THIS literal
+	fieldNames := resolveFieldNames(cl.Type, inheritedFieldNames, structDefs)
+
+	// Convert if positional and we know the field names
+	if len(fieldNames) > 0 && isPositionalLiteral(cl) {
+		convertToKeyedLiteral(cl, fieldNames)
+	}
+
+	// Determine field names to pass to children (from element type)
+	childFieldNames := getElementFieldNames(cl.Type, structDefs)
+
+	// Process all child elements
+	for _, elt := range cl.Elts {
+		processElement(elt, childFieldNames, structDefs)
+	}
+}
+
+func processElement(elt dst.Expr, inheritedFieldNames []string, structDefs map[string][]string) {
+	switch e := elt.(type) {
+	case *dst.CompositeLit:
+		processCompositeLit(e, inheritedFieldNames, structDefs)
+	case *dst.KeyValueExpr:
+		// Value might be a composite literal (map values, struct fields)
+		if child, ok := e.Value.(*dst.CompositeLit); ok {
+			processCompositeLit(child, inheritedFieldNames, structDefs)
+		}
+	}
+}
+
+func reorderCompositeLitRecursive(cl *dst.CompositeLit, inheritedFieldOrder []string, structDefs map[string][]string) {
+	// Determine field order for THIS literal
+	fieldOrder := resolveSortedFieldOrder(cl.Type, inheritedFieldOrder, structDefs)
+
+	// Reorder if we know the field order
+	if len(fieldOrder) > 0 {
+		reorderCompositeLitFields(cl, fieldOrder)
+	}
+
+	// Determine field order to pass to children (from element type)
+	childFieldOrder := getElementSortedFieldOrder(cl.Type, structDefs)
+
+	// Process all child elements
+	for _, elt := range cl.Elts {
+		reorderElementRecursive(elt, childFieldOrder, structDefs)
+	}
+}
+
+func reorderElementRecursive(elt dst.Expr, inheritedFieldOrder []string, structDefs map[string][]string) {
+	switch e := elt.(type) {
+	case *dst.CompositeLit:
+		reorderCompositeLitRecursive(e, inheritedFieldOrder, structDefs)
+	case *dst.KeyValueExpr:
+		// Value might be a composite literal (map values, struct fields)
+		if child, ok := e.Value.(*dst.CompositeLit); ok {
+			reorderCompositeLitRecursive(child, inheritedFieldOrder, structDefs)
+		}
+	}
+}
+
+func collectStructDefinitions(f *dst.File, stable bool) map[string][]string {
 	structDefs := make(map[string][]string)
 
 	dst.Inspect(f, func(n dst.Node) bool {
@@ -29,7 +109,7 @@ func collectStructDefinitions(f *dst.File) map[string][]string {
 			return true
 		}
 
-		structDefs[ts.Name.Name] = computeFieldOrder(st)
+		structDefs[ts.Name.Name] = computeFieldOrder(st, stable)
 
 		return true
 	})
@@ -37,6 +117,60 @@ func collectStructDefinitions(f *dst.File) map[string][]string {
 	return structDefs
 }
 
+func getElementFieldNames(t dst.Expr, structDefs map[string][]string) []string {
+	if t == nil {
+		return nil
+	}
+
+	// Slice/array: []T or [N]T
+	if at, ok := t.(*dst.ArrayType); ok {
+		return resolveFieldNames(at.Elt, nil, structDefs)
+	}
+
+	// Map: map[K]V - return value type's field names
+	if mt, ok := t.(*dst.MapType); ok {
+		return resolveFieldNames(mt.Value, nil, structDefs)
+	}
+
+	return nil
+}
+
+func getElementSortedFieldOrder(t dst.Expr, structDefs map[string][]string) []string {
+	if t == nil {
+		return nil
+	}
+
+	// Slice/array: []T or [N]T
+	if at, ok := t.(*dst.ArrayType); ok {
+		return resolveSortedFieldOrder(at.Elt, nil, structDefs)
+	}
+
+	// Map: map[K]V - return value type's field order
+	if mt, ok := t.(*dst.MapType); ok {
+		return resolveSortedFieldOrder(mt.Value, nil, structDefs)
+	}
+
+	return nil
+}
+
+// reorderStructFields reorders fields of all structs in the file, except for the
+// named structs in pinned, whose field order must be kept. In stable mode,
+// fields keep their relative order within each group.
+func reorderStructFields(f *dst.File, pinned map[string]bool, stable bool) {
+	dst.Inspect(f, func(n dst.Node) bool {
+		switch node := n.(type) {
+		case *dst.TypeSpec:
+			if pinned[node.Name.Name] {
+				return false
+			}
+		case *dst.StructType:
+			reorderFields(node, stable)
+		}
+
+		return true
+	})
+}
+
 // collectOriginalFieldOrder collects the original (unsorted) field order for each struct.
 // This is needed for converting positional literals to keyed literals.
 func collectOriginalFieldOrder(f *dst.File) map[string][]string {
@@ -60,7 +194,26 @@ func collectOriginalFieldOrder(f *dst.File) map[string][]string {
 	return structDefs
 }
 
-func reorderFields(st *dst.StructType) {
+func computeFieldOrder(st *dst.StructType, stable bool) []string {
+	var embedded, public, private []string
+
+	for _, field := range st.Fields.List {
+		if len(field.Names) == 0 {
+			embedded = append(embedded, getFieldTypeName(field))
+		} else {
+			name := field.Names[0].Name
+			if isExported(name) {
+				public = append(public, name)
+			} else {
+				private = append(private, name)
+			}
+		}
+	}
+
+	return orderFieldNames(embedded, public, private, stable)
+}
+
+func reorderFields(st *dst.StructType, stable bool) {
 	if st.Fields == nil || len(st.Fields.List) == 0 {
 		return
 	}
@@ -77,56 +230,33 @@ func reorderFields(st *dst.StructType) {
 		}
 	}
 
-	sortFieldsByTypeName(embedded)
-	sortFieldsByName(public)
-	sortFieldsByName(private)
+	if !stable {
+		sortFieldsByTypeName(embedded)
+		sortFieldsByName(public)
+		sortFieldsByName(private)
+	}
 
 	st.Fields.List = assembleFieldList(embedded, public, private)
 }
 
-func reorderStructLiterals(f *dst.File, structDefs map[string][]string) {
-	dst.Inspect(f, func(n dst.Node) bool {
-		cl, ok := n.(*dst.CompositeLit)
-		if !ok {
-			return true
-		}
-
-		// Process this literal and all nested children for reordering
-		reorderCompositeLitRecursive(cl, nil, structDefs)
-
-		// Don't let dst.Inspect descend into children - we handle them
-		return false
-	})
-}
-
-func reorderCompositeLitRecursive(cl *dst.CompositeLit, inheritedFieldOrder []string, structDefs map[string][]string) {
-	// Determine field order for THIS literal
-	fieldOrder := resolveSortedFieldOrder(cl.Type, inheritedFieldOrder, structDefs)
-
-	// Reorder if we know the field order
-	if len(fieldOrder) > 0 {
-		reorderCompositeLitFields(cl, fieldOrder)
+func resolveFieldNames(t dst.Expr, inherited []string, structDefs map[string][]string) []string {
+	if t == nil {
+		return inherited
 	}
 
-	// Determine field order to pass to children (from element type)
-	childFieldOrder := getElementSortedFieldOrder(cl.Type, structDefs)
-
-	// Process all child elements
-	for _, elt := range cl.Elts {
-		reorderElementRecursive(elt, childFieldOrder, structDefs)
+	// Anonymous struct type
+	if st, ok := t.(*dst.StructType); ok {
+		return getFieldNamesFromStructType(st)
 	}
-}
 
-func reorderElementRecursive(elt dst.Expr, inheritedFieldOrder []string, structDefs map[string][]string) {
-	switch e := elt.(type) {
-	case *dst.CompositeLit:
-		reorderCompositeLitRecursive(e, inheritedFieldOrder, structDefs)
-	case *dst.KeyValueExpr:
-		// Value might be a composite literal (map values, struct fields)
-		if child, ok := e.Value.(*dst.CompositeLit); ok {
-			reorderCompositeLitRecursive(child, inheritedFieldOrder, structDefs)
+	// Named type
+	if typeName := literalTypeName(t); typeName != "" {
+		if names, exists := structDefs[typeName]; exists {
+			return names
 		}
 	}
+
+	return nil
 }
 
 func resolveSortedFieldOrder(t dst.Expr, inherited []string, structDefs map[string][]string) []string {
@@ -140,7 +270,7 @@ func resolveSortedFieldOrder(t dst.Expr, inherited []string, structDefs map[stri
 	}
 
 	// Named type
-	if typeName := extractTypeName(t); typeName != "" {
+	if typeName := literalTypeName(t); typeName != "" {
 		if order, exists := structDefs[typeName]; exists {
 			return order
 		}
@@ -149,24 +279,6 @@ func resolveSortedFieldOrder(t dst.Expr, inherited []string, structDefs map[stri
 	return nil
 }
 
-func getElementSortedFieldOrder(t dst.Expr, structDefs map[string][]string) []string {
-	if t == nil {
-		return nil
-	}
-
-	// Slice/array: []T or [N]T
-	if at, ok := t.(*dst.ArrayType); ok {
-		return resolveSortedFieldOrder(at.Elt, nil, structDefs)
-	}
-
-	// Map: map[K]V - return value type's field order
-	if mt, ok := t.(*dst.MapType); ok {
-		return resolveSortedFieldOrder(mt.Value, nil, structDefs)
-	}
-
-	return nil
-}
-
 func assembleFieldList(embedded, public, private []*dst.Field) []*dst.Field {
 	var result []*dst.Field
 
@@ -198,25 +310,70 @@ func assembleFieldList(embedded, public, private []*dst.Field) []*dst.Field {
 	return result
 }
 
-func computeFieldOrder(st *dst.StructType) []string {
-	var embedded, public, private []string
+func convertToKeyedLiteral(cl *dst.CompositeLit, fieldNames []string) {
+	if len(fieldNames) == 0 || len(cl.Elts) == 0 {
+		return
+	}
+
+	newElts := make([]dst.Expr, 0, len(cl.Elts))
+	for i, elt := range cl.Elts {
+		if i >= len(fieldNames) {
+			break
+		}
 
+		kv := &dst.KeyValueExpr{
+			Key:   dst.NewIdent(fieldNames[i]),
+			Value: elt,
+		}
+		newElts = append(newElts, kv)
+	}
+
+	cl.Elts = newElts
+}
+
+func getFieldNamesFromStructType(st *dst.StructType) []string {
+	if st == nil || st.Fields == nil {
+		return nil
+	}
+
+	var names []string
 	for _, field := range st.Fields.List {
 		if len(field.Names) == 0 {
-			embedded = append(embedded, getFieldTypeName(field))
+			// Embedded field - use type name
+			names = append(names, getFieldTypeName(field))
 		} else {
-			name := field.Names[0].Name
-			if isExported(name) {
-				public = append(public, name)
-			} else {
-				private = append(private, name)
+			// Named field(s)
+			for _, name := range field.Names {
+				names = append(names, name.Name)
 			}
 		}
 	}
 
-	sort.Strings(embedded)
-	sort.Strings(public)
-	sort.Strings(private)
+	return names
+}
+
+func isPositionalLiteral(cl *dst.CompositeLit) bool {
+	if len(cl.Elts) == 0 {
+		return false
+	}
+
+	for _, elt := range cl.Elts {
+		if _, ok := elt.(*dst.KeyValueExpr); ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// orderFieldNames concatenates the embedded, public and private field names,
+// each group sorted by name unless stable is set.
+func orderFieldNames(embedded, public, private []string, stable bool) []string {
+	if !stable {
+		sort.Strings(embedded)
+		sort.Strings(public)
+		sort.Strings(private)
+	}
 
 	result := make([]string, 0, len(embedded)+len(public)+len(private))
 	result = append(result, embedded...)
@@ -283,142 +440,3 @@ func reorderCompositeLitFields(cl *dst.CompositeLit, fieldOrder []string) {
 
 	cl.Elts = newElts
 }
-
-func isPositionalLiteral(cl *dst.CompositeLit) bool {
-	if len(cl.Elts) == 0 {
-		return false
-	}
-
-	for _, elt := range cl.Elts {
-		if _, ok := elt.(*dst.KeyValueExpr); ok {
-			return false
-		}
-	}
-
-	return true
-}
-
-func getFieldNamesFromStructType(st *dst.StructType) []string {
-	if st == nil || st.Fields == nil {
-		return nil
-	}
-
-	var names []string
-	for _, field := range st.Fields.List {
-		if len(field.Names) == 0 {
-			// Embedded field - use type name
-			names = append(names, getFieldTypeName(field))
-		} else {
-			// Named field(s)
-			for _, name := range field.Names {
-				names = append(names, name.Name)
-			}
-		}
-	}
-
-	return names
-}
-
-func convertToKeyedLiteral(cl *dst.CompositeLit, fieldNames []string) {
-	if len(fieldNames) == 0 || len(cl.Elts) == 0 {
-		return
-	}
-
-	newElts := make([]dst.Expr, 0, len(cl.Elts))
-	for i, elt := range cl.Elts {
-		if i >= len(fieldNames) {
-			break
-		}
-
-		kv := &dst.KeyValueExpr{
-			Key:   dst.NewIdent(fieldNames[i]),
-			Value: elt,
-		}
-		newElts = append(newElts, kv)
-	}
-
-	cl.Elts = newElts
-}
-
-func convertPositionalToKeyed(f *dst.File, structDefs map[string][]string) {
-	dst.Inspect(f, func(n dst.Node) bool {
-		cl, ok := n.(*dst.CompositeLit)
-		if !ok {
-			return true
-		}
-
-		// Process this literal and all nested children
-		processCompositeLit(cl, nil, structDefs)
-
-		// Don't let dst.Inspect descend into children - we handle them
-		return false
-	})
-}
-
-func processCompositeLit(cl *dst.CompositeLit, inheritedFieldNames []string, structDefs map[string][]string) {
-	// Determine field names for THIS literal
-	fieldNames := resolveFieldNames(cl.Type, inheritedFieldNames, structDefs)
-
-	// Convert if positional and we know the field names
-	if len(fieldNames) > 0 && isPositionalLiteral(cl) {
-		convertToKeyedLiteral(cl, fieldNames)
-	}
-
-	// Determine field names to pass to children (from element type)
-	childFieldNames := getElementFieldNames(cl.Type, structDefs)
-
-	// Process all child elements
-	for _, elt := range cl.Elts {
-		processElement(elt, childFieldNames, structDefs)
-	}
-}
-
-func processElement(elt dst.Expr, inheritedFieldNames []string, structDefs map[string][]string) {
-	switch e := elt.(type) {
-	case *dst.CompositeLit:
-		processCompositeLit(e, inheritedFieldNames, structDefs)
-	case *dst.KeyValueExpr:
-		// Value might be a composite literal (map values, struct fields)
-		if child, ok := e.Value.(*dst.CompositeLit); ok {
-			processCompositeLit(child, inheritedFieldNames, structDefs)
-		}
-	}
-}
-
-func resolveFieldNames(t dst.Expr, inherited []string, structDefs map[string][]string) []string {
-	if t == nil {
-		return inherited
-	}
-
-	// Anonymous struct type
-	if st, ok := t.(*dst.StructType); ok {
-		return getFieldNamesFromStructType(st)
-	}
-
-	// Named type
-	if typeName := extractTypeName(t); typeName != "" {
-		if names, exists := structDefs[typeName]; exists {
-			return names
-		}
-	}
-
-	return nil
-}
-
-func getElementFieldNames(t dst.Expr, structDefs map[string][]string) []string {
-	if t == nil {
-		return nil
-	}
-
-	// Slice/array: []T or [N]T
-	if at, ok := t.(*dst.ArrayType); ok {
-		return resolveFieldNames(at.Elt, nil, structDefs)
-	}
-
-	// Map: map[K]V - return value type's field names
-	if mt, ok := t.(*dst.MapType); ok {
-		return resolveFieldNames(mt.Value, nil, structDefs)
-	}
-
-	return nil
-}
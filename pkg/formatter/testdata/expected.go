@@ -22,7 +22,9 @@ const (
 	ConstA      = "a"
 	ConstB      = "b"
 	ConstMiddle = "m"
-	ConstZ      = "z"
+
+	// ConstZ is merged into the const block and sorted.
+	ConstZ = "z"
 
 	StatusError   StatusCode = "error"
 	StatusOK      StatusCode = "ok"
@@ -39,20 +41,16 @@ const (
 )
 
 var (
+	// Test: blank var interface check
 	_ fmt.Stringer = (*Server)(nil)
 	_ Reader       = (*Server)(nil)
 	_ Writer       = (*Client)(nil)
 
-	GlobalPublic = "public"
-
-	DefaultStatus StatusCode = "default"
-	ErrorStatus   StatusCode = "error"
+	globalB      = 3
+	globalMiddle = 7
+	singleConst  = 1
 
-	globalA        = 5
-	globalB        = 3
-	globalMiddle   = 7
-	globalZ        = 10
-	singleConst    = 1
+	// Test: slice of anonymous structs with positional literals
 	sliceOfStructs = []struct {
 		content string
 		path    string
@@ -62,6 +60,20 @@ var (
 	}
 )
 
+// Test: commented var block should stay together and be sorted
+var (
+	GlobalPublic = "public"
+
+	globalA = 5
+	globalZ = 10
+)
+
+// Test: custom type grouping in var block
+var (
+	DefaultStatus StatusCode = "default"
+	ErrorStatus   StatusCode = "error"
+)
+
 // Test: type declared in wrong place
 type Processor func(input string) (output string, err error)
 
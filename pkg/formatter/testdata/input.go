@@ -18,12 +18,14 @@ func main() {
 	fmt.Println("main")
 }
 
-// Test: vars should be merged and sorted
-var globalZ = 10
-var globalA = 5
-var GlobalPublic = "public"
+// Test: commented var block should stay together and be sorted
+var (
+	globalZ      = 10
+	globalA      = 5
+	GlobalPublic = "public"
+)
 
-// Test: consts should be merged and sorted
+// ConstZ is merged into the const block and sorted.
 const ConstZ = "z"
 const constPrivate = "private"
 
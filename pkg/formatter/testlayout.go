@@ -0,0 +1,211 @@
+package formatter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dave/dst"
+)
+
+const (
+	// Orders of tests, benchmarks and fuzz targets in test files. See
+	// Options.TestLayout.
+	TestLayoutAlphabetical = "alphabetical"
+	TestLayoutOriginal     = "original"
+)
+
+// testFuncPrefixes are the name prefixes of the functions go test runs, in
+// layout order.
+var testFuncPrefixes = []string{"Test", "Benchmark", "Fuzz", "Example"}
+
+// sortTestFuncs orders TestMain first, then tests, benchmarks, fuzz targets
+// and examples. Examples are ordered as godoc displays them; the others keep
+// their order unless sorted alphabetically. The layout is opt-in and applies
+// in stable mode too.
+func sortTestFuncs(funcs []*dst.FuncDecl, layout string) {
+	sort.SliceStable(funcs, func(i, j int) bool {
+		rankI, rankJ := testFuncRank(funcs[i]), testFuncRank(funcs[j])
+		if rankI != rankJ {
+			return rankI < rankJ
+		}
+		if rankI == len(testFuncPrefixes) {
+			return exampleSortKey(funcs[i].Name.Name) < exampleSortKey(funcs[j].Name.Name)
+		}
+
+		return layout == TestLayoutAlphabetical && funcs[i].Name.Name < funcs[j].Name.Name
+	})
+}
+
+// moveHelpersFirst moves the test helpers, the functions calling t.Helper(),
+// before the other functions. Both keep their order.
+func moveHelpersFirst(functions []dst.Decl) []dst.Decl {
+	var helpers, others []dst.Decl
+	for _, d := range functions {
+		if fn, ok := d.(*dst.FuncDecl); ok && callsHelper(fn) {
+			helpers = append(helpers, d)
+		} else {
+			others = append(others, d)
+		}
+	}
+
+	return append(helpers, others...)
+}
+
+// testFuncRank returns the position of a function in the test layout: 0 for
+// TestMain, then 1 to 4 for tests, benchmarks, fuzz targets and examples. It
+// returns -1 for other functions.
+func testFuncRank(fn *dst.FuncDecl) int {
+	if fn.Recv != nil {
+		return -1
+	}
+	if fn.Name.Name == "TestMain" {
+		return 0
+	}
+	for i, prefix := range testFuncPrefixes {
+		if isTestFuncName(fn.Name.Name, prefix) {
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+// callsHelper reports whether a function marks itself as a test helper with
+// t.Helper(), b.Helper() or tb.Helper().
+func callsHelper(fn *dst.FuncDecl) bool {
+	if fn.Body == nil {
+		return false
+	}
+
+	var found bool
+	dst.Inspect(fn.Body, func(n dst.Node) bool {
+		if call, ok := n.(*dst.CallExpr); ok && len(call.Args) == 0 {
+			if sel, ok := call.Fun.(*dst.SelectorExpr); ok && sel.Sel.Name == "Helper" {
+				found = true
+			}
+		}
+
+		return !found
+	})
+
+	return found
+}
+
+// exampleSortKey returns the key examples are sorted by, as godoc displays
+// them: package examples (Example, Example_suffix) first, then the examples of
+// every identifier by name.
+func exampleSortKey(name string) string {
+	id := strings.TrimPrefix(name, "Example")
+	if id == "" || id[0] == '_' {
+		return "\x00" + id
+	}
+
+	return id
+}
+
+// isTestFuncName reports whether name is prefix followed by nothing or by a
+// character that is not a lower-case letter, as go test requires.
+func isTestFuncName(name, prefix string) bool {
+	rest, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+
+	return rest == "" || !unicode.IsLower(r)
+}
+
+// layoutTestFunctions returns the functions section of a test file: the test
+// functions followed by the other functions, with every type placed right
+// before the first function referencing it. The units of the types not
+// referenced by any function are returned.
+func layoutTestFunctions(testFuncs []*dst.FuncDecl, functions []dst.Decl, units []typeUnit) ([]dst.Decl, []typeUnit) {
+	funcs := make([]dst.Decl, 0, len(testFuncs)+len(functions))
+	for _, fn := range testFuncs {
+		funcs = append(funcs, fn)
+	}
+	funcs = append(funcs, functions...)
+
+	unitIndex := make(map[string]int)
+	for i, unit := range units {
+		if unit.name != "" {
+			unitIndex[unit.name] = i
+		}
+	}
+	firstUser := make(map[int]int)
+	for i, fn := range funcs {
+		dst.Inspect(fn, func(n dst.Node) bool {
+			if ident, ok := n.(*dst.Ident); ok {
+				if u, ok := unitIndex[ident.Name]; ok {
+					if _, claimed := firstUser[u]; !claimed {
+						firstUser[u] = i
+					}
+				}
+			}
+
+			return true
+		})
+	}
+
+	var rest []typeUnit
+	claimed := make([][]typeUnit, len(funcs))
+	for u, unit := range units {
+		if i, ok := firstUser[u]; ok {
+			claimed[i] = append(claimed[i], unit)
+		} else {
+			rest = append(rest, unit)
+		}
+	}
+
+	result := make([]dst.Decl, 0, len(funcs))
+	for i, fn := range funcs {
+		for _, unit := range claimed[i] {
+			result = append(result, unit.decls...)
+		}
+		result = append(result, fn)
+	}
+
+	return result, rest
+}
+
+// referencedNames returns the identifiers used by testFuncs and functions,
+// among them the names of the types layoutTestFunctions places before a
+// function.
+func referencedNames(testFuncs []*dst.FuncDecl, functions []dst.Decl) map[string]bool {
+	names := make(map[string]bool)
+	visit := func(n dst.Node) bool {
+		if ident, ok := n.(*dst.Ident); ok {
+			names[ident.Name] = true
+		}
+
+		return true
+	}
+	for _, fn := range testFuncs {
+		dst.Inspect(fn, visit)
+	}
+	for _, fn := range functions {
+		dst.Inspect(fn, visit)
+	}
+
+	return names
+}
+
+func testLayoutOf(filePath, layout string) string {
+	if !isTestFile(filePath) {
+		return ""
+	}
+
+	return layout
+}
+
+func validateTestLayout(layout string) error {
+	switch layout {
+	case "", TestLayoutAlphabetical, TestLayoutOriginal:
+		return nil
+	}
+
+	return fmt.Errorf("test layout: unknown value %q (known: %s, %s)", layout, TestLayoutOriginal, TestLayoutAlphabetical)
+}
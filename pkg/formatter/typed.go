@@ -0,0 +1,182 @@
+package formatter
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"os"
+	"path/filepath"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"golang.org/x/tools/go/packages"
+)
+
+const typedLoadMode = packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedImports | packages.NeedDeps | packages.NeedTypes | packages.NeedTypesInfo
+
+// literalTypes maps composite literals to the structs they instantiate, as
+// resolved by the type checker.
+type literalTypes map[*dst.CompositeLit]*typedLiteral
+
+// typedLiteral describes the struct instantiated by a composite literal.
+type typedLiteral struct {
+	// fieldNames is the declared field order.
+	fieldNames []string
+	// localName is the struct name if it is declared in the formatted package.
+	localName        string
+	sortedFieldNames []string
+}
+
+func newTypedLiteral(t types.Type, pkg *types.Package, stable bool) *typedLiteral {
+	if t == nil {
+		return nil
+	}
+	t = types.Unalias(t)
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = types.Unalias(ptr.Elem())
+	}
+
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok || st.NumFields() == 0 {
+		return nil
+	}
+
+	lit := &typedLiteral{}
+	if named, ok := t.(*types.Named); ok && named.Obj().Pkg() == pkg {
+		lit.localName = named.Obj().Name()
+	}
+
+	var embedded, public, private []string
+	for i := range st.NumFields() {
+		field := st.Field(i)
+		lit.fieldNames = append(lit.fieldNames, field.Name())
+		switch {
+		case field.Embedded():
+			embedded = append(embedded, field.Name())
+		case field.Exported():
+			public = append(public, field.Name())
+		default:
+			private = append(private, field.Name())
+		}
+	}
+
+	lit.sortedFieldNames = orderFieldNames(embedded, public, private, stable)
+
+	return lit
+}
+
+// loadTypedFiles type-checks the package in dir, including its tests, from
+// local sources and the module cache, and returns its files by absolute path.
+// The network is never used: missing modules are reported as errors.
+func loadTypedFiles(dir string, stable bool) (map[string]*sourceFile, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &packages.Config{
+		Mode:  typedLoadMode,
+		Dir:   absDir,
+		Env:   append(os.Environ(), "GOPROXY=off"),
+		Tests: true,
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		return nil, fmt.Errorf("load package in %s: %w", dir, err)
+	}
+
+	files := make(map[string]*sourceFile)
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 {
+			return nil, fmt.Errorf("load package in %s: %w", dir, pkg.Errors[0])
+		}
+
+		dec := decorator.NewDecorator(pkg.Fset)
+		for _, astFile := range pkg.Syntax {
+			filePath := pkg.Fset.File(astFile.Pos()).Name()
+			if _, ok := files[filePath]; ok || filepath.Dir(filePath) != absDir {
+				continue
+			}
+
+			src, err := os.ReadFile(filePath)
+			if err != nil {
+				return nil, err
+			}
+
+			f, err := dec.DecorateFile(astFile)
+			if err != nil {
+				return nil, err
+			}
+
+			files[filePath] = &sourceFile{
+				file:     f,
+				literals: resolveLiteralTypes(f, dec.Ast.Nodes, pkg, stable),
+				path:     filePath,
+				src:      src,
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// applyLiteralTypes converts positional literals to keyed ones and reorders
+// keyed literals for every literal resolved by the type checker. Literals of
+// pinned local structs keep the declared field order.
+func applyLiteralTypes(literals literalTypes, pinned map[string]bool) {
+	for cl, lit := range literals {
+		if isPositionalLiteral(cl) && len(cl.Elts) == len(lit.fieldNames) {
+			convertToKeyedLiteral(cl, lit.fieldNames)
+		}
+
+		order := lit.sortedFieldNames
+		if lit.localName != "" && pinned[lit.localName] {
+			order = lit.fieldNames
+		}
+		reorderCompositeLitFields(cl, order)
+	}
+}
+
+// collectTypedFieldNames returns, for every composite literal of the file in
+// traversal order, the declared field names of its struct if the literal is
+// positional and resolved by the type checker, and nil otherwise.
+func collectTypedFieldNames(f *dst.File, literals literalTypes) [][]string {
+	var result [][]string
+	dst.Inspect(f, func(n dst.Node) bool {
+		cl, ok := n.(*dst.CompositeLit)
+		if !ok {
+			return true
+		}
+		if lit, ok := literals[cl]; ok && isPositionalLiteral(cl) && len(cl.Elts) == len(lit.fieldNames) {
+			result = append(result, lit.fieldNames)
+		} else {
+			result = append(result, nil)
+		}
+
+		return true
+	})
+
+	return result
+}
+
+func resolveLiteralTypes(f *dst.File, astNodes map[dst.Node]ast.Node, pkg *packages.Package, stable bool) literalTypes {
+	literals := make(literalTypes)
+
+	dst.Inspect(f, func(n dst.Node) bool {
+		cl, ok := n.(*dst.CompositeLit)
+		if !ok {
+			return true
+		}
+		astLit, ok := astNodes[cl].(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		if lit := newTypedLiteral(pkg.TypesInfo.TypeOf(astLit), pkg.Types, stable); lit != nil {
+			literals[cl] = lit
+		}
+
+		return true
+	})
+
+	return literals
+}
@@ -0,0 +1,368 @@
+package formatter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+var (
+	ErrNotEquivalent = errors.New("formatted code is not equivalent to the original")
+
+	decorationsType = reflect.TypeOf(dst.Decorations{})
+)
+
+type canonicalFile struct {
+	comments []string
+	decls    []string
+}
+
+// verifyEquivalence checks that formatted differs from original only in
+// ordering and whitespace. fieldOrder holds the original field order of the
+// structs whose positional literals may have been converted, typedFieldNames
+// the field names of literals converted using type information (see
+// collectTypedFieldNames).
+func verifyEquivalence(filePath string, original, formatted []byte, fieldOrder map[string][]string, typedFieldNames [][]string) error {
+	want, err := canonicalizeSource(original, fieldOrder, typedFieldNames)
+	if err != nil {
+		return fmt.Errorf("%s: verify original: %w", filePath, err)
+	}
+
+	got, err := canonicalizeSource(formatted, fieldOrder, nil)
+	if err != nil {
+		return fmt.Errorf("%s: verify formatted: %w", filePath, err)
+	}
+
+	if diff := firstDifference(want.decls, got.decls); diff != "" {
+		return fmt.Errorf("%s: %w: declarations differ:\n%s", filePath, ErrNotEquivalent, diff)
+	}
+
+	if diff := commentDifference(want.comments, got.comments); diff != "" {
+		return fmt.Errorf("%s: %w: comments differ:\n%s", filePath, ErrNotEquivalent, diff)
+	}
+
+	return nil
+}
+
+// canonicalizeSource parses src and renders every top-level declaration in a
+// canonical form: decorations stripped, positional literals keyed, and
+// declarations, struct fields and keyed literal elements sorted. Comments are
+// collected separately, as their placement is whitespace.
+func canonicalizeSource(src []byte, fieldOrder map[string][]string, typedFieldNames [][]string) (*canonicalFile, error) {
+	f, err := decorator.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if typedFieldNames != nil {
+		convertTypedPositionalLiterals(f, typedFieldNames)
+	}
+	convertPositionalToKeyed(f, fieldOrder)
+	clotheNakedReturns(f, nil)
+
+	result := &canonicalFile{}
+	dst.Inspect(f, func(n dst.Node) bool {
+		if n != nil {
+			result.comments = append(result.comments, stripDecorations(n)...)
+		}
+
+		return true
+	})
+	sort.Strings(result.comments)
+
+	canonicalizeNodes(f)
+
+	for _, decl := range flattenDecls(f.Decls) {
+		rendered, err := renderDecl(f.Name.Name, decl)
+		if err != nil {
+			return nil, err
+		}
+		result.decls = append(result.decls, rendered)
+	}
+	sort.Strings(result.decls)
+
+	return result, nil
+}
+
+func canonicalizeNodes(f *dst.File) {
+	dst.Inspect(f, func(n dst.Node) bool {
+		switch node := n.(type) {
+		case *dst.FieldList:
+			node.List = splitMultiNameFields(node.List)
+		case *dst.StructType:
+			if node.Fields != nil {
+				sort.SliceStable(node.Fields.List, func(i, j int) bool {
+					return canonicalFieldKey(node.Fields.List[i]) < canonicalFieldKey(node.Fields.List[j])
+				})
+			}
+		case *dst.InterfaceType:
+			if node.Methods != nil {
+				sort.SliceStable(node.Methods.List, func(i, j int) bool {
+					return canonicalFieldKey(node.Methods.List[i]) < canonicalFieldKey(node.Methods.List[j])
+				})
+			}
+		case *dst.CompositeLit:
+			sortKeyedElements(node)
+		case *dst.BasicLit:
+			node.Value = canonicalLiteralValue(node)
+		}
+
+		return true
+	})
+}
+
+func canonicalLiteralValue(lit *dst.BasicLit) string {
+	if lit.Kind != token.INT {
+		return lit.Value
+	}
+
+	// gofumpt rewrites legacy octal literals (0755 -> 0o755).
+	if len(lit.Value) > 1 && lit.Value[0] == '0' && strings.IndexFunc(lit.Value[1:], isNotOctalDigit) == -1 {
+		return "0o" + lit.Value[1:]
+	}
+
+	return lit.Value
+}
+
+// clotheNakedReturns fills in the named results of naked returns, as gofumpt
+// does, so that both forms compare equal. results are those of the function
+// enclosing node.
+func clotheNakedReturns(node dst.Node, results *dst.FieldList) {
+	dst.Inspect(node, func(n dst.Node) bool {
+		switch n := n.(type) {
+		case *dst.FuncDecl:
+			if n.Body != nil {
+				clotheNakedReturns(n.Body, n.Type.Results)
+			}
+
+			return false
+		case *dst.FuncLit:
+			clotheNakedReturns(n.Body, n.Type.Results)
+
+			return false
+		case *dst.ReturnStmt:
+			if len(n.Results) == 0 {
+				n.Results = resultIdents(results)
+			}
+		}
+
+		return true
+	})
+}
+
+func sortKeyedElements(cl *dst.CompositeLit) {
+	keyed, rest := splitKeyedElements(cl.Elts)
+	sort.SliceStable(keyed, func(i, j int) bool {
+		return keyed[i].Key.(*dst.Ident).Name < keyed[j].Key.(*dst.Ident).Name
+	})
+
+	elts := make([]dst.Expr, 0, len(cl.Elts))
+	for _, kv := range keyed {
+		elts = append(elts, kv)
+	}
+	cl.Elts = append(elts, rest...)
+}
+
+// stripDecorations removes every decoration of the node, including the
+// node-specific ones (e.g. after "func" or the opening paren), and returns the
+// normalised comments that were attached to it.
+func stripDecorations(n dst.Node) []string {
+	decs := reflect.ValueOf(n).Elem().FieldByName("Decs")
+	if !decs.IsValid() {
+		return nil
+	}
+
+	var comments []string
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch {
+		case v.Type() == decorationsType:
+			for _, d := range v.Interface().(dst.Decorations) {
+				if isCommentLine(d) {
+					comments = append(comments, normalizeComment(d))
+				}
+			}
+			v.Set(reflect.Zero(v.Type()))
+		case v.Kind() == reflect.Struct:
+			for i := range v.NumField() {
+				walk(v.Field(i))
+			}
+		case v.Type() == reflect.TypeOf(dst.None):
+			v.Set(reflect.Zero(v.Type()))
+		}
+	}
+	walk(decs)
+
+	return comments
+}
+
+func canonicalFieldKey(field *dst.Field) string {
+	if len(field.Names) == 0 {
+		return getFieldTypeName(field)
+	}
+
+	return field.Names[0].Name
+}
+
+func commentDifference(want, got []string) string {
+	counts := make(map[string]int)
+	for _, c := range want {
+		counts[c]++
+	}
+	for _, c := range got {
+		counts[c]--
+	}
+
+	var lines []string
+	for _, c := range want {
+		if counts[c] > 0 {
+			lines = append(lines, "missing: "+c)
+			counts[c]--
+		}
+	}
+	for _, c := range got {
+		if counts[c] < 0 {
+			lines = append(lines, "added: "+c)
+			counts[c]++
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func convertTypedPositionalLiterals(f *dst.File, typedFieldNames [][]string) {
+	var i int
+	dst.Inspect(f, func(n dst.Node) bool {
+		cl, ok := n.(*dst.CompositeLit)
+		if !ok {
+			return true
+		}
+		if i < len(typedFieldNames) && typedFieldNames[i] != nil {
+			convertToKeyedLiteral(cl, typedFieldNames[i])
+		}
+		i++
+
+		return true
+	})
+}
+
+func firstDifference(want, got []string) string {
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w != g {
+			return fmt.Sprintf("original:\n%s\nformatted:\n%s", w, g)
+		}
+	}
+
+	return ""
+}
+
+// flattenDecls splits grouped declarations into one declaration per spec.
+// Blocks using iota are kept intact since their specs depend on position.
+func flattenDecls(decls []dst.Decl) []dst.Decl {
+	var result []dst.Decl
+	for _, decl := range decls {
+		gd, ok := decl.(*dst.GenDecl)
+		if !ok || hasIota(gd) {
+			result = append(result, decl)
+			continue
+		}
+		for _, spec := range gd.Specs {
+			result = append(result, &dst.GenDecl{Tok: gd.Tok, Specs: []dst.Spec{spec}})
+		}
+	}
+
+	return result
+}
+
+func isNotOctalDigit(r rune) bool {
+	return r < '0' || r > '7'
+}
+
+func normalizeComment(comment string) string {
+	if strings.HasPrefix(comment, "//") {
+		return strings.TrimSpace(strings.TrimPrefix(comment, "//"))
+	}
+
+	return strings.Join(strings.Fields(comment), " ")
+}
+
+func renderDecl(pkgName string, decl dst.Decl) (string, error) {
+	var buf bytes.Buffer
+	f := &dst.File{Name: dst.NewIdent(pkgName), Decls: []dst.Decl{decl}}
+	if err := decorator.Fprint(&buf, f); err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(buf.String(), "package "+pkgName+"\n\n"), nil
+}
+
+// resultIdents returns the names of named results, or nil if results are
+// unnamed or include a blank name, which gofumpt leaves naked.
+func resultIdents(results *dst.FieldList) []dst.Expr {
+	if results == nil {
+		return nil
+	}
+
+	var idents []dst.Expr
+	for _, field := range results.List {
+		for _, name := range field.Names {
+			if name.Name == "_" {
+				return nil
+			}
+			idents = append(idents, dst.NewIdent(name.Name))
+		}
+	}
+
+	return idents
+}
+
+func splitKeyedElements(elts []dst.Expr) ([]*dst.KeyValueExpr, []dst.Expr) {
+	var keyed []*dst.KeyValueExpr
+	var rest []dst.Expr
+	for _, elt := range elts {
+		if kv, ok := elt.(*dst.KeyValueExpr); ok {
+			if _, ok := kv.Key.(*dst.Ident); ok {
+				keyed = append(keyed, kv)
+				continue
+			}
+		}
+		rest = append(rest, elt)
+	}
+
+	return keyed, rest
+}
+
+// splitMultiNameFields expands "a, b int" into "a int" and "b int", since
+// gofumpt joins adjacent parameters of the same type.
+func splitMultiNameFields(fields []*dst.Field) []*dst.Field {
+	var result []*dst.Field
+	for _, field := range fields {
+		if len(field.Names) <= 1 {
+			result = append(result, field)
+			continue
+		}
+		for _, name := range field.Names {
+			result = append(result, &dst.Field{
+				Names: []*dst.Ident{name},
+				Type:  dst.Clone(field.Type).(dst.Expr),
+				Tag:   field.Tag,
+			})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,188 @@
+package formatter
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyEquivalence(t *testing.T) {
+	original := `package main
+
+// Server serves.
+type Server struct {
+	port int
+	name string
+}
+
+func run(s *Server) int { return s.port + 1 }
+
+var srv = Server{8080, "srv"}
+`
+	fieldOrder := map[string][]string{"Server": {"port", "name"}}
+	tests := []struct {
+		equivalent bool
+		formatted  string
+		name       string
+	}{
+		{
+			equivalent: true,
+			formatted: `package main
+
+var srv = Server{name: "srv", port: 8080}
+
+// Server serves.
+type Server struct {
+	name string
+	port int
+}
+
+func run(s *Server) int {
+	return s.port + 1
+}
+`,
+			name: "reordered",
+		},
+		{
+			formatted: `package main
+
+// Server serves.
+type Server struct {
+	port int
+	name string
+}
+
+func run(s *Server) int { return s.port - 1 }
+
+var srv = Server{8080, "srv"}
+`,
+			name: "changed expression",
+		},
+		{
+			formatted: `package main
+
+// Server serves.
+type Server struct {
+	port int
+	name string
+}
+
+func run(s *Server) int { return s.port + 1 }
+
+var srv = Server{name: "srv", port: 80}
+`,
+			name: "changed literal",
+		},
+		{
+			formatted: `package main
+
+// Server serves.
+type Server struct {
+	port int
+	name string
+}
+
+func run(s *Server) int { return s.port + 1 }
+`,
+			name: "dropped declaration",
+		},
+		{
+			formatted: `package main
+
+type Server struct {
+	port int
+	name string
+}
+
+func run(s *Server) int { return s.port + 1 }
+
+var srv = Server{8080, "srv"}
+`,
+			name: "dropped comment",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyEquivalence("main.go", []byte(original), []byte(tt.formatted), fieldOrder, nil)
+			if tt.equivalent && err != nil {
+				t.Errorf("verification failed: %v", err)
+			}
+			if !tt.equivalent && !errors.Is(err, ErrNotEquivalent) {
+				t.Errorf("verification should fail with ErrNotEquivalent, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyEquivalenceNakedReturns(t *testing.T) {
+	original := `package main
+
+func read() (n int, err error) {
+	n = 1
+	if n > 0 {
+		return
+	}
+	f := func() (ok bool) {
+		return
+	}
+	f()
+
+	return
+}
+`
+	tests := []struct {
+		equivalent bool
+		formatted  string
+		name       string
+	}{
+		{
+			equivalent: true,
+			formatted: `package main
+
+func read() (n int, err error) {
+	n = 1
+	if n > 0 {
+		return n, err
+	}
+	f := func() (ok bool) {
+		return ok
+	}
+	f()
+
+	return n, err
+}
+`,
+			name: "clothed",
+		},
+		{
+			formatted: `package main
+
+func read() (n int, err error) {
+	n = 1
+	if n > 0 {
+		return 0, nil
+	}
+	f := func() (ok bool) {
+		return ok
+	}
+	f()
+
+	return n, err
+}
+`,
+			name: "changed results",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyEquivalence("main.go", []byte(original), []byte(tt.formatted), nil, nil)
+			if tt.equivalent && err != nil {
+				t.Errorf("verification failed: %v", err)
+			}
+			if !tt.equivalent && !errors.Is(err, ErrNotEquivalent) {
+				t.Errorf("verification should fail with ErrNotEquivalent, got: %v", err)
+			}
+		})
+	}
+}